@@ -1,9 +1,15 @@
 package searchparty
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,6 +17,9 @@ import (
 
 const MdRinfo = "17106176" // Either 17106176 or 50660608
 
+// defaultAnisetteTTL is how long the OTP returned by the anisette server is reused
+const defaultAnisetteTTL = 30 * time.Second
+
 type AnisetteResponse struct {
 	XAppleIClientTime time.Time `json:"X-Apple-I-Client-Time"`
 	XAppleIMD         string    `json:"X-Apple-I-MD"`
@@ -24,27 +33,171 @@ type AnisetteResponse struct {
 	XMmeDeviceId      string    `json:"X-Mme-Device-Id"`
 }
 
-func getAnisetteHeaders(anisetteUrl string) (http.Header, error) {
-	req, err := http.NewRequest("GET", anisetteUrl, nil)
+// AnisetteProvider returns the anisette headers attached to the requests sent to Apple
+type AnisetteProvider interface {
+	Headers(ctx context.Context) (http.Header, error)
+}
+
+// AnisetteSource returns fresh anisette data
+type AnisetteSource interface {
+	Fetch(ctx context.Context) (*AnisetteResponse, error)
+}
+
+// AnisetteIdentity is the device identity presented to Apple. It must be
+// stable across requests (and runs) so that the account sees a single device.
+type AnisetteIdentity struct {
+	DeviceID     string `json:"deviceId"`
+	LocalUserID  string `json:"localUserId"`
+	SerialNumber string `json:"serialNumber"`
+}
+
+// RemoteAnisetteProvider fetches anisette data from an anisette server
+// (e.g. dadoum/anisette-v3-server)
+type RemoteAnisetteProvider struct {
+	URL        string
+	HTTPClient *http.Client
+	UserAgent  string
+}
+
+var (
+	_ AnisetteSource   = (*RemoteAnisetteProvider)(nil)
+	_ AnisetteProvider = (*RemoteAnisetteProvider)(nil)
+)
+
+func (p *RemoteAnisetteProvider) Fetch(ctx context.Context) (*AnisetteResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
 	if err != nil {
 		return nil, err
 	}
-	res, err := http.DefaultClient.Do(req)
+	if p.UserAgent != "" {
+		req.Header.Set("User-Agent", p.UserAgent)
+	}
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
 	var response AnisetteResponse
 	err = json.NewDecoder(res.Body).Decode(&response)
 	if err != nil {
 		return nil, err
 	}
-	userId := uuid.NewString()
-	userId = strings.Replace(userId, "-", "", -1)
-	userId = strings.ToUpper(userId)
+	return &response, nil
+}
+
+// Headers returns the anisette headers using a new random identity on every call.
+// Use a CachingAnisetteProvider to keep the identity stable.
+func (p *RemoteAnisetteProvider) Headers(ctx context.Context) (http.Header, error) {
+	response, err := p.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return anisetteHeaders(response, newAnisetteIdentity(response)), nil
+}
+
+// CachingAnisetteProvider wraps an AnisetteSource, keeping the device identity
+// stable (optionally persisting it to a file) and reusing the OTP until it expires
+type CachingAnisetteProvider struct {
+	source       AnisetteSource
+	identityFile string
+	ttl          time.Duration
+
+	mu        sync.Mutex
+	identity  *AnisetteIdentity
+	cached    *AnisetteResponse
+	fetchedAt time.Time
+}
+
+var _ AnisetteProvider = (*CachingAnisetteProvider)(nil)
+
+// NewCachingAnisetteProvider returns a provider that caches the anisette data of source for ttl.
+// If identityFile is not empty, the device identity is loaded from (and saved to) that file.
+func NewCachingAnisetteProvider(source AnisetteSource, identityFile string, ttl time.Duration) (*CachingAnisetteProvider, error) {
+	if ttl <= 0 {
+		ttl = defaultAnisetteTTL
+	}
+	p := CachingAnisetteProvider{
+		source:       source,
+		identityFile: identityFile,
+		ttl:          ttl,
+	}
+	if identityFile != "" {
+		identity, err := loadAnisetteIdentity(identityFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load anisette identity: %w", err)
+		}
+		p.identity = identity
+	}
+	return &p, nil
+}
+
+func (p *CachingAnisetteProvider) Headers(ctx context.Context) (http.Header, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached == nil || time.Since(p.fetchedAt) > p.ttl {
+		response, err := p.source.Fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.cached = response
+		p.fetchedAt = time.Now()
+	}
+
+	if p.identity == nil {
+		identity := newAnisetteIdentity(p.cached)
+		p.identity = &identity
+		if p.identityFile != "" {
+			if err := saveAnisetteIdentity(p.identityFile, p.identity); err != nil {
+				return nil, fmt.Errorf("unable to save anisette identity: %w", err)
+			}
+		}
+	}
+	return anisetteHeaders(p.cached, *p.identity), nil
+}
 
-	deviceId := strings.ToUpper(uuid.NewString())
+// Identity returns the device identity in use, or nil if it hasn't been created yet
+func (p *CachingAnisetteProvider) Identity() *AnisetteIdentity {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.identity == nil {
+		return nil
+	}
+	identity := *p.identity
+	return &identity
+}
 
-	headers := http.Header{
+// newAnisetteIdentity creates an identity, preferring the values provided by
+// the anisette server and generating random ones for the missing fields
+func newAnisetteIdentity(response *AnisetteResponse) AnisetteIdentity {
+	identity := AnisetteIdentity{
+		DeviceID:     response.XMmeDeviceId,
+		LocalUserID:  response.XAppleIMDLU,
+		SerialNumber: response.XAppleISRLNO,
+	}
+	if identity.DeviceID == "" {
+		identity.DeviceID = strings.ToUpper(uuid.NewString())
+	}
+	if identity.LocalUserID == "" {
+		userId := uuid.NewString()
+		userId = strings.Replace(userId, "-", "", -1)
+		identity.LocalUserID = strings.ToUpper(userId)
+	}
+	if identity.SerialNumber == "" {
+		identity.SerialNumber = "0"
+	}
+	return identity
+}
+
+func anisetteHeaders(response *AnisetteResponse, identity AnisetteIdentity) http.Header {
+	return http.Header{
 		"X-Apple-I-MD":          []string{response.XAppleIMD},
 		"X-Apple-I-MD-M":        []string{response.XAppleIMDM},
 		"X-Apple-I-Client-Time": []string{time.Now().UTC().Format(time.RFC3339)},
@@ -52,10 +205,33 @@ func getAnisetteHeaders(anisetteUrl string) (http.Header, error) {
 		"loc":                   []string{"en_US"},
 		"X-Apple-Locale":        []string{"en_US"},
 		"X-Apple-I-MD-RINFO":    []string{MdRinfo},
-		"X-Apple-I-MD-LU":       []string{userId},
-		"X-Mme-Device-Id":       []string{deviceId},
-		"X-Apple-I-SRL-NO":      []string{"0"},
+		"X-Apple-I-MD-LU":       []string{identity.LocalUserID},
+		"X-Mme-Device-Id":       []string{identity.DeviceID},
+		"X-Apple-I-SRL-NO":      []string{identity.SerialNumber},
 	}
+}
 
-	return headers, nil
+// loadAnisetteIdentity reads the identity stored in path, returning nil if the file doesn't exist
+func loadAnisetteIdentity(path string) (*AnisetteIdentity, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var identity AnisetteIdentity
+	if err := json.NewDecoder(f).Decode(&identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func saveAnisetteIdentity(path string, identity *AnisetteIdentity) error {
+	b, err := json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
 }
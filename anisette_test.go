@@ -0,0 +1,88 @@
+package searchparty
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeAnisetteSource struct {
+	calls    int
+	response AnisetteResponse
+}
+
+func (f *fakeAnisetteSource) Fetch(ctx context.Context) (*AnisetteResponse, error) {
+	f.calls++
+	response := f.response
+	return &response, nil
+}
+
+// header returns the first value of h[key]. Anisette headers use
+// non-canonical keys, so http.Header.Get can't be used.
+func header(h http.Header, key string) string {
+	if len(h[key]) == 0 {
+		return ""
+	}
+	return h[key][0]
+}
+
+func TestCachingAnisetteProvider(t *testing.T) {
+	identityFile := filepath.Join(t.TempDir(), "identity.json")
+	source := &fakeAnisetteSource{response: AnisetteResponse{XAppleIMD: "otp", XAppleISRLNO: "serial"}}
+	p, err := NewCachingAnisetteProvider(source, identityFile, time.Hour)
+	if err != nil {
+		t.Fatalf("NewCachingAnisetteProvider failed: %v", err)
+	}
+
+	h1, err := p.Headers(context.Background())
+	if err != nil {
+		t.Fatalf("Headers failed: %v", err)
+	}
+	h2, err := p.Headers(context.Background())
+	if err != nil {
+		t.Fatalf("Headers failed: %v", err)
+	}
+	if source.calls != 1 {
+		t.Errorf("expected the anisette data to be cached, got %d fetches", source.calls)
+	}
+	if h1.Get("X-Mme-Device-Id") == "" || h1.Get("X-Mme-Device-Id") != h2.Get("X-Mme-Device-Id") {
+		t.Errorf("device ID is not stable: %q, %q", h1.Get("X-Mme-Device-Id"), h2.Get("X-Mme-Device-Id"))
+	}
+	if header(h1, "X-Apple-I-SRL-NO") != "serial" {
+		t.Errorf("expected the serial number of the anisette server, got %q", header(h1, "X-Apple-I-SRL-NO"))
+	}
+
+	// A new provider must reuse the persisted identity
+	p2, err := NewCachingAnisetteProvider(&fakeAnisetteSource{}, identityFile, time.Hour)
+	if err != nil {
+		t.Fatalf("NewCachingAnisetteProvider failed: %v", err)
+	}
+	h3, err := p2.Headers(context.Background())
+	if err != nil {
+		t.Fatalf("Headers failed: %v", err)
+	}
+	for _, h := range []string{"X-Mme-Device-Id", "X-Apple-I-MD-LU", "X-Apple-I-SRL-NO"} {
+		if header(h1, h) != header(h3, h) {
+			t.Errorf("%s was not persisted: %q != %q", h, header(h1, h), header(h3, h))
+		}
+	}
+}
+
+func TestCachingAnisetteProviderExpiry(t *testing.T) {
+	source := &fakeAnisetteSource{}
+	p, err := NewCachingAnisetteProvider(source, "", time.Nanosecond)
+	if err != nil {
+		t.Fatalf("NewCachingAnisetteProvider failed: %v", err)
+	}
+	for range 2 {
+		if _, err := p.Headers(context.Background()); err != nil {
+			t.Fatalf("Headers failed: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if source.calls != 2 {
+		t.Errorf("expected the expired anisette data to be refreshed, got %d fetches", source.calls)
+	}
+}
@@ -0,0 +1,405 @@
+package searchparty
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"howett.net/plist"
+)
+
+const gsaLookupURL = "https://gsa.apple.com/grandslam/GsService2/lookup"
+
+// AnisetteV3State is the provisioned ADI state. It isn't tied to a specific
+// anisette v3 server, so the same state can be used with any of them.
+type AnisetteV3State struct {
+	// Identifier is the random 16 bytes identifier of the virtual device
+	Identifier []byte `json:"identifier"`
+	// AdiPb is the provisioning data returned at the end of the provisioning
+	AdiPb []byte `json:"adi_pb,omitempty"`
+}
+
+// DeviceID returns the X-Mme-Device-Id derived from the identifier
+func (s *AnisetteV3State) DeviceID() string {
+	id, err := uuid.FromBytes(s.Identifier)
+	if err != nil {
+		return strings.ToUpper(hex.EncodeToString(s.Identifier))
+	}
+	return strings.ToUpper(id.String())
+}
+
+// LocalUserID returns the X-Apple-I-MD-LU derived from the identifier
+func (s *AnisetteV3State) LocalUserID() string {
+	return strings.ToUpper(hex.EncodeToString(sha256Hash(s.Identifier)))
+}
+
+// AnisetteV3Provider implements the anisette v3 protocol: the device is
+// provisioned once through a v3 server (the provisioning requests to Apple are
+// sent by this client) and the resulting state is stored in StateFile.
+type AnisetteV3Provider struct {
+	// URL is the base URL of the anisette v3 server
+	URL string
+	// StateFile is the file storing the provisioned state
+	StateFile string
+	// HTTPClient is used to talk to the anisette server
+	HTTPClient *http.Client
+	// AppleHTTPClient is used to talk to gsa.apple.com, see NewAppleHTTPClient
+	AppleHTTPClient *http.Client
+	// LookupURL is the URL returning the provisioning endpoints (default: gsa.apple.com)
+	LookupURL string
+
+	mu         sync.Mutex
+	state      *AnisetteV3State
+	clientInfo *anisetteV3ClientInfo
+}
+
+var _ AnisetteSource = (*AnisetteV3Provider)(nil)
+
+type anisetteV3ClientInfo struct {
+	ClientInfo string `json:"client_info"`
+	UserAgent  string `json:"user_agent"`
+}
+
+// anisetteV3Message is a message received from the anisette server,
+// either on the provisioning websocket or from /v3/get_headers
+type anisetteV3Message struct {
+	Result         string `json:"result"`
+	Message        string `json:"message"`
+	Cpim           string `json:"cpim"`
+	AdiPb          string `json:"adi_pb"`
+	XAppleIMD      string `json:"X-Apple-I-MD"`
+	XAppleIMDM     string `json:"X-Apple-I-MD-M"`
+	XAppleIMDRINFO string `json:"X-Apple-I-MD-RINFO"`
+}
+
+// errAnisetteV3Headers is returned when the server can't generate headers for the stored state
+var errAnisetteV3Headers = errors.New("anisette v3 server rejected the provisioning data")
+
+func (p *AnisetteV3Provider) Fetch(ctx context.Context) (*AnisetteResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.loadState(); err != nil {
+		return nil, err
+	}
+	if p.state.AdiPb == nil {
+		if err := p.provision(ctx); err != nil {
+			return nil, fmt.Errorf("unable to provision: %w", err)
+		}
+	}
+	headers, err := p.getHeaders(ctx)
+	if errors.Is(err, errAnisetteV3Headers) {
+		logger.Warnf("%v, provisioning again", err)
+		if err := p.provision(ctx); err != nil {
+			return nil, fmt.Errorf("unable to provision: %w", err)
+		}
+		headers, err = p.getHeaders(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnisetteResponse{
+		XAppleIClientTime: time.Now(),
+		XAppleIMD:         headers.XAppleIMD,
+		XAppleIMDM:        headers.XAppleIMDM,
+		XAppleIMDRINFO:    headers.XAppleIMDRINFO,
+		XAppleIMDLU:       p.state.LocalUserID(),
+		XMmeDeviceId:      p.state.DeviceID(),
+		XAppleISRLNO:      "0",
+		XMMeClientInfo:    p.clientInfo.ClientInfo,
+	}, nil
+}
+
+// loadState loads the state from StateFile, creating a new identifier if needed
+func (p *AnisetteV3Provider) loadState() error {
+	if p.state != nil {
+		return nil
+	}
+	var state AnisetteV3State
+	b, err := os.ReadFile(p.StateFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("unable to read anisette state: %w", err)
+	default:
+		if err := json.Unmarshal(b, &state); err != nil {
+			return fmt.Errorf("unable to decode anisette state: %w", err)
+		}
+	}
+	if len(state.Identifier) == 0 {
+		state.Identifier = make([]byte, 16)
+		if _, err := rand.Read(state.Identifier); err != nil {
+			return fmt.Errorf("unable to generate identifier: %w", err)
+		}
+	}
+	p.state = &state
+	return p.saveState()
+}
+
+func (p *AnisetteV3Provider) saveState() error {
+	if p.StateFile == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(p.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.StateFile, b, 0o600)
+}
+
+func (p *AnisetteV3Provider) httpClient() *http.Client {
+	if p.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return p.HTTPClient
+}
+
+func (p *AnisetteV3Provider) appleHTTPClient() *http.Client {
+	if p.AppleHTTPClient == nil {
+		return http.DefaultClient
+	}
+	return p.AppleHTTPClient
+}
+
+func (p *AnisetteV3Provider) endpoint(path string) string {
+	return strings.TrimSuffix(p.URL, "/") + path
+}
+
+func (p *AnisetteV3Provider) getClientInfo(ctx context.Context) error {
+	if p.clientInfo != nil {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint("/v3/client_info"), nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to get client info: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get client info: unexpected status code: %d", res.StatusCode)
+	}
+	var clientInfo anisetteV3ClientInfo
+	if err := json.NewDecoder(res.Body).Decode(&clientInfo); err != nil {
+		return fmt.Errorf("unable to decode client info: %w", err)
+	}
+	p.clientInfo = &clientInfo
+	return nil
+}
+
+func (p *AnisetteV3Provider) getHeaders(ctx context.Context) (*anisetteV3Message, error) {
+	if err := p.getClientInfo(ctx); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]string{
+		"identifier": base64.StdEncoding.EncodeToString(p.state.Identifier),
+		"adi_pb":     base64.StdEncoding.EncodeToString(p.state.AdiPb),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint("/v3/get_headers"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get headers: %w", err)
+	}
+	defer res.Body.Close()
+	var msg anisetteV3Message
+	if err := json.NewDecoder(res.Body).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("unable to decode headers: %w", err)
+	}
+	switch msg.Result {
+	case "Headers":
+		return &msg, nil
+	case "GetHeadersError":
+		return nil, fmt.Errorf("%w: %s", errAnisetteV3Headers, msg.Message)
+	default:
+		return nil, fmt.Errorf("unexpected result %q: %s", msg.Result, msg.Message)
+	}
+}
+
+// provision runs the start-provisioning/end-provisioning flow over the
+// provisioning_session websocket of the anisette server
+func (p *AnisetteV3Provider) provision(ctx context.Context) error {
+	if err := p.getClientInfo(ctx); err != nil {
+		return err
+	}
+	startURL, finishURL, err := p.lookupProvisioningURLs(ctx)
+	if err != nil {
+		return err
+	}
+
+	wsURL, err := url.Parse(p.endpoint("/v3/provisioning_session"))
+	if err != nil {
+		return err
+	}
+	switch wsURL.Scheme {
+	case "https":
+		wsURL.Scheme = "wss"
+	default:
+		wsURL.Scheme = "ws"
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("unable to open provisioning session: %w", err)
+	}
+	defer conn.Close()
+
+	for {
+		var msg anisetteV3Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return fmt.Errorf("unable to read message: %w", err)
+		}
+		var reply map[string]string
+		switch msg.Result {
+		case "GiveIdentifier":
+			reply = map[string]string{"identifier": base64.StdEncoding.EncodeToString(p.state.Identifier)}
+		case "GiveStartProvisioningData":
+			res, err := p.postProvisioning(ctx, startURL, map[string]any{}, "spim")
+			if err != nil {
+				return fmt.Errorf("start provisioning: %w", err)
+			}
+			reply = map[string]string{"spim": res["spim"]}
+		case "GiveEndProvisioningData":
+			res, err := p.postProvisioning(ctx, finishURL, map[string]any{"cpim": msg.Cpim}, "ptm", "tk")
+			if err != nil {
+				return fmt.Errorf("end provisioning: %w", err)
+			}
+			reply = map[string]string{"ptm": res["ptm"], "tk": res["tk"]}
+		case "ProvisioningSuccess":
+			adiPb, err := base64.StdEncoding.DecodeString(msg.AdiPb)
+			if err != nil {
+				return fmt.Errorf("unable to decode adi_pb: %w", err)
+			}
+			p.state.AdiPb = adiPb
+			return p.saveState()
+		default:
+			return fmt.Errorf("unexpected result %q: %s", msg.Result, msg.Message)
+		}
+		if err := conn.WriteJSON(reply); err != nil {
+			return fmt.Errorf("unable to write message: %w", err)
+		}
+	}
+}
+
+// appleHeaders returns the headers sent to gsa.apple.com during the provisioning
+func (p *AnisetteV3Provider) appleHeaders() http.Header {
+	return http.Header{
+		"User-Agent":            []string{p.clientInfo.UserAgent},
+		"X-Mme-Client-Info":     []string{p.clientInfo.ClientInfo},
+		"X-Mme-Device-Id":       []string{p.state.DeviceID()},
+		"X-Apple-I-MD-LU":       []string{p.state.LocalUserID()},
+		"X-Apple-I-Client-Time": []string{time.Now().UTC().Format(time.RFC3339)},
+		"X-Apple-I-TimeZone":    []string{time.Now().Format("MST")},
+		"X-Apple-Locale":        []string{"en_US"},
+		"X-Apple-I-SRL-NO":      []string{"0"},
+		"Content-Type":          []string{"application/x-www-form-urlencoded"},
+	}
+}
+
+func (p *AnisetteV3Provider) lookupProvisioningURLs(ctx context.Context) (string, string, error) {
+	lookupURL := p.LookupURL
+	if lookupURL == "" {
+		lookupURL = gsaLookupURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lookupURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header = p.appleHeaders()
+	res, err := p.appleHTTPClient().Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to lookup provisioning URLs: %w", err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+	var lookup struct {
+		URLs map[string]string `plist:"urls"`
+	}
+	if _, err := plist.Unmarshal(b, &lookup); err != nil {
+		return "", "", fmt.Errorf("unable to decode lookup response: %w", err)
+	}
+	startURL, finishURL := lookup.URLs["midStartProvisioning"], lookup.URLs["midFinishProvisioning"]
+	if startURL == "" || finishURL == "" {
+		return "", "", errors.New("provisioning URLs not found in lookup response")
+	}
+	return startURL, finishURL, nil
+}
+
+// postProvisioning sends a provisioning request to Apple and returns the
+// "Response" dictionary, which must contain the keys
+func (p *AnisetteV3Provider) postProvisioning(ctx context.Context, endpoint string, request map[string]any, keys ...string) (map[string]string, error) {
+	body, err := plist.Marshal(map[string]any{
+		"Header":  map[string]any{},
+		"Request": request,
+	}, plist.XMLFormat)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = p.appleHeaders()
+	res, err := p.appleHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Response map[string]any `plist:"Response"`
+	}
+	if _, err := plist.Unmarshal(b, &response); err != nil {
+		return nil, fmt.Errorf("unable to decode response: %w", err)
+	}
+	// Apple reports the errors in the status, with a non-zero error code
+	if status, ok := response.Response["Status"].(map[string]any); ok {
+		if ec := fmt.Sprint(status["ec"]); status["ec"] != nil && ec != "0" {
+			return nil, fmt.Errorf("apple returned error %s: %v", ec, status["em"])
+		}
+	}
+	values := make(map[string]string)
+	for k, v := range response.Response {
+		if str, ok := v.(string); ok {
+			values[k] = str
+		}
+	}
+	for _, k := range keys {
+		if values[k] == "" {
+			return nil, fmt.Errorf("%s missing from the response", k)
+		}
+	}
+	return values, nil
+}
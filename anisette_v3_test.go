@@ -0,0 +1,170 @@
+package searchparty
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"howett.net/plist"
+)
+
+func newFakeAppleProvisioning(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	writePlist := func(w http.ResponseWriter, v any) {
+		b, err := plist.Marshal(v, plist.XMLFormat)
+		if err != nil {
+			t.Fatalf("unable to marshal plist: %v", err)
+		}
+		_, _ = w.Write(b)
+	}
+	mux.HandleFunc("/lookup", func(w http.ResponseWriter, r *http.Request) {
+		writePlist(w, map[string]any{"urls": map[string]string{
+			"midStartProvisioning":  srv.URL + "/start",
+			"midFinishProvisioning": srv.URL + "/finish",
+		}})
+	})
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		writePlist(w, map[string]any{"Response": map[string]string{"spim": "spim-data"}})
+	})
+	mux.HandleFunc("/finish", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var req struct {
+			Request map[string]string `plist:"Request"`
+		}
+		if _, err := plist.Unmarshal(b, &req); err != nil || req.Request["cpim"] != "cpim-data" {
+			t.Errorf("unexpected finish request: %s", b)
+		}
+		writePlist(w, map[string]any{"Response": map[string]string{"ptm": "ptm-data", "tk": "tk-data"}})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newFakeAnisetteV3Server(t *testing.T, provisionings *int) *httptest.Server {
+	t.Helper()
+	adiPb := base64.StdEncoding.EncodeToString([]byte("adi-pb"))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/client_info", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(anisetteV3ClientInfo{ClientInfo: "client-info", UserAgent: "akd/1.0"})
+	})
+	mux.HandleFunc("/v3/provisioning_session", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("unable to upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		steps := []struct {
+			send   map[string]string
+			expect string
+		}{
+			{map[string]string{"result": "GiveIdentifier"}, "identifier"},
+			{map[string]string{"result": "GiveStartProvisioningData"}, "spim"},
+			{map[string]string{"result": "GiveEndProvisioningData", "cpim": "cpim-data"}, "ptm"},
+		}
+		for _, step := range steps {
+			if err := conn.WriteJSON(step.send); err != nil {
+				t.Errorf("unable to write: %v", err)
+				return
+			}
+			var reply map[string]string
+			if err := conn.ReadJSON(&reply); err != nil {
+				t.Errorf("unable to read: %v", err)
+				return
+			}
+			if reply[step.expect] == "" {
+				t.Errorf("expected %q in reply, got %v", step.expect, reply)
+			}
+		}
+		*provisionings++
+		_ = conn.WriteJSON(map[string]string{"result": "ProvisioningSuccess", "adi_pb": adiPb})
+	})
+	mux.HandleFunc("/v3/get_headers", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["adi_pb"] != adiPb {
+			_ = json.NewEncoder(w).Encode(map[string]string{"result": "GetHeadersError", "message": "bad adi_pb"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"result":             "Headers",
+			"X-Apple-I-MD":       "otp",
+			"X-Apple-I-MD-M":     "machine",
+			"X-Apple-I-MD-RINFO": MdRinfo,
+		})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAnisetteV3Provider(t *testing.T) {
+	var provisionings int
+	apple := newFakeAppleProvisioning(t)
+	anisette := newFakeAnisetteV3Server(t, &provisionings)
+	stateFile := filepath.Join(t.TempDir(), "anisette-v3.json")
+
+	p := &AnisetteV3Provider{URL: anisette.URL, StateFile: stateFile, LookupURL: apple.URL + "/lookup"}
+	res, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if res.XAppleIMD != "otp" || res.XAppleIMDM != "machine" {
+		t.Errorf("unexpected headers: %+v", res)
+	}
+	if res.XMmeDeviceId == "" || res.XAppleIMDLU == "" {
+		t.Errorf("missing identity: %+v", res)
+	}
+
+	// A new provider with the same state must not provision again and keep the identity
+	p2 := &AnisetteV3Provider{URL: anisette.URL, StateFile: stateFile, LookupURL: apple.URL + "/lookup"}
+	res2, err := p2.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if provisionings != 1 {
+		t.Errorf("expected a single provisioning, got %d", provisionings)
+	}
+	if res.XMmeDeviceId != res2.XMmeDeviceId || res.XAppleIMDLU != res2.XAppleIMDLU {
+		t.Errorf("identity changed: %+v != %+v", res, res2)
+	}
+}
+
+func TestAnisetteV3ProvisioningError(t *testing.T) {
+	responses := map[string]map[string]any{
+		"/error": {"Status": map[string]any{"ec": -45061, "em": "device not allowed"}},
+		"/empty": {"Status": map[string]any{"ec": 0}},
+		"/ok":    {"Status": map[string]any{"ec": 0}, "spim": "spim-data"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := plist.Marshal(map[string]any{"Response": responses[r.URL.Path]}, plist.XMLFormat)
+		if err != nil {
+			t.Errorf("unable to marshal plist: %v", err)
+		}
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	p := &AnisetteV3Provider{state: &AnisetteV3State{}, clientInfo: &anisetteV3ClientInfo{}}
+	for path, want := range map[string]string{
+		"/error": "apple returned error -45061: device not allowed",
+		"/empty": "spim missing from the response",
+	} {
+		if _, err := p.postProvisioning(context.Background(), srv.URL+path, map[string]any{}, "spim"); err == nil || err.Error() != want {
+			t.Errorf("%s: expected %q, got %v", path, want, err)
+		}
+	}
+	res, err := p.postProvisioning(context.Background(), srv.URL+"/ok", map[string]any{}, "spim")
+	if err != nil || res["spim"] != "spim-data" {
+		t.Errorf("unexpected response %v: %v", res, err)
+	}
+}
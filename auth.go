@@ -1,13 +1,71 @@
 package searchparty
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
 type Auth struct {
 	Dsid             string `json:"dsid"`
 	SearchPartyToken string `json:"searchPartyToken"`
+
+	// The following fields are only set when logging in with searchparty login
+	// and are used to renew the search party token when it expires
+
+	AppleID string `json:"appleId,omitempty"`
+	ADSID   string `json:"adsid,omitempty"`
+	// PET is the password equivalent token
+	PET          string `json:"pet,omitempty"`
+	MmeAuthToken string `json:"mmeAuthToken,omitempty"`
+}
+
+// TokenRefresher renews the search party token of an expired Auth
+type TokenRefresher interface {
+	Refresh(ctx context.Context, auth *Auth) (*Auth, error)
+}
+
+// credentials holds the Auth of a Client, renewing it when it expires
+type credentials struct {
+	mu        sync.RWMutex
+	auth      *Auth
+	refresher TokenRefresher
+	authFile  string
+}
+
+func (c *credentials) get() *Auth {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.auth
+}
+
+// refresh renews the token if stale is still the current Auth. Concurrent
+// callers that got a 401 with the same Auth share a single renewal.
+func (c *credentials) refresh(ctx context.Context, stale *Auth) (*Auth, error) {
+	if c.refresher == nil {
+		return nil, errors.New("no token refresher configured")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.auth != stale {
+		return c.auth, nil
+	}
+	auth, err := c.refresher.Refresh(ctx, c.auth)
+	if err != nil {
+		return nil, err
+	}
+	if c.authFile != "" {
+		if err := SaveAuth(c.authFile, auth); err != nil {
+			return nil, fmt.Errorf("unable to save auth: %w", err)
+		}
+	}
+	logger.Infof("search party token renewed")
+	c.auth = auth
+	return auth, nil
 }
 
 func GetAuth(authFile string) (*Auth, error) {
@@ -23,3 +81,28 @@ func GetAuth(authFile string) (*Auth, error) {
 	}
 	return &auth, nil
 }
+
+// SaveAuth atomically writes auth to authFile
+func SaveAuth(authFile string, auth *Auth) error {
+	b, err := json.MarshalIndent(auth, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(authFile), ".auth-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), authFile)
+}
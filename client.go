@@ -5,23 +5,52 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/exp/maps"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
 	"github.com/denysvitali/searchparty-go/model"
 )
 
 var logger = logrus.StandardLogger().WithField("pkg", "searchparty")
 
-const fetchReportsUrl = "https://gateway.icloud.com/acsnservice/fetch"
+const (
+	defaultBaseURL   = "https://gateway.icloud.com"
+	fetchReportsPath = "/acsnservice/fetch"
+
+	// defaultBatchSize is the maximum number of IDs sent in a single request
+	defaultBatchSize = 256
+	// defaultMaxWindow is the longest time range covered by a single request
+	defaultMaxWindow = 7 * 24 * time.Hour
+	// defaultConcurrency is the maximum number of requests in flight for a Find call
+	defaultConcurrency = 4
+	// defaultRateLimit is the sustained rate of requests sent to Apple
+	defaultRateLimit = rate.Limit(1)
+	// defaultRateBurst is the amount of requests that can be sent at once
+	defaultRateBurst = 4
+)
 
 type Client struct {
-	auth        *Auth
-	anisetteUrl string
+	creds       *credentials
+	anisette    AnisetteProvider
+	baseURL     string
+	httpClient  *http.Client
+	timeout     time.Duration
+	userAgent   string
+	batchSize   int
+	maxWindow   time.Duration
+	concurrency int
+	limiter     *rate.Limiter
+	maxRetries  int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
 }
 
 type searchParams struct {
@@ -42,63 +71,259 @@ type Report struct {
 	StatusCode    int    `json:"statusCode"`
 }
 
+// FindOptions describes the reports to look for
+type FindOptions struct {
+	// From is the start of the time range (required)
+	From time.Time
+	// To is the end of the time range, defaults to now
+	To time.Time
+	// LostAt is the time the keys were marked as lost, defaults to From. The
+	// keys are derived from the later of LostAt and the start of each window.
+	LostAt time.Time
+	// KeyFilter optionally restricts the advertisement keys that are queried
+	KeyFilter func(model.SubKey) bool
+}
+
+// Find returns the reports published in the last hours
 func (c Client) Find(ctx context.Context, keys []model.MainKey, hours int, lostAt time.Time) ([]Report, map[string]model.SubKey, error) {
-	h, err := getAnisetteHeaders(c.anisetteUrl)
+	return c.FindRange(ctx, keys, FindOptions{
+		From:   time.Now().Add(-time.Duration(hours) * time.Hour),
+		LostAt: lostAt,
+	})
+}
+
+// FindRange returns the reports published between opts.From and opts.To
+func (c Client) FindRange(ctx context.Context, keys []model.MainKey, opts FindOptions) ([]Report, map[string]model.SubKey, error) {
+	startTime := opts.From
+	endTime := opts.To
+	if endTime.IsZero() {
+		endTime = time.Now()
+	}
+	if startTime.IsZero() || !startTime.Before(endTime) {
+		return nil, nil, fmt.Errorf("invalid time range: %s - %s", startTime, endTime)
+	}
+	lostAt := opts.LostAt
+	if lostAt.IsZero() {
+		lostAt = startTime
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	h, err := c.anisette.Headers(ctx)
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to get anisette headers: %w", err)
 	}
 
-	now := time.Now()
-	startTime := now.Add(-time.Duration(hours) * time.Hour)
-	endTime := now
-
-	start := startTime.Unix()
-	end := endTime.Unix()
-
 	subKeysMap := make(map[string]model.SubKey)
-	for _, k := range keys {
-		subKeys, err := k.GetSubKeys(startTime, endTime, lostAt)
-		if err != nil {
-			return nil, nil, fmt.Errorf("unable to get subkeys: %w", err)
+	var search []searchParams
+	for _, w := range splitWindow(startTime, endTime, c.maxWindow) {
+		windowKeys := make(map[string]struct{})
+		for _, k := range keys {
+			subKeys, err := k.GetSubKeys(w.from, w.to, lostAt)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to get subkeys: %w", err)
+			}
+			for _, v := range subKeys {
+				if opts.KeyFilter != nil && !opts.KeyFilter(v) {
+					continue
+				}
+				id := base64.StdEncoding.EncodeToString(v.HashedAdvKey)
+				subKeysMap[id] = v
+				windowKeys[id] = struct{}{}
+			}
+		}
+		if len(windowKeys) == 0 {
+			continue
 		}
-		for _, v := range subKeys {
-			subKeysMap[base64.StdEncoding.EncodeToString(v.HashedAdvKey)] = v
+		keyIDs := maps.Keys(windowKeys)
+		sort.Strings(keyIDs)
+		for _, ids := range chunk(keyIDs, c.batchSize) {
+			search = append(search, searchParams{
+				StartDate: w.from.Unix() * 1000,
+				EndDate:   w.to.Unix() * 1000,
+				Ids:       ids,
+			})
 		}
 	}
+	logger.Debugf("fetching %d ids in %d requests", len(subKeysMap), len(search))
+
+	results := make([][]Report, len(search))
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+	for i, p := range search {
+		g.Go(func() error {
+			reports, err := c.fetch(gCtx, h, p)
+			if err != nil {
+				return err
+			}
+			results[i] = reports
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	return mergeReports(results), subKeysMap, nil
+}
 
-	keyIDs := maps.Keys(subKeysMap)
+// fetch performs a single request against the fetch endpoint, retrying
+// on rate limits and server errors
+func (c Client) fetch(ctx context.Context, h http.Header, p searchParams) ([]Report, error) {
 	jsonBytes, err := json.Marshal(FindRequest{
-		Search: []searchParams{{
-			StartDate: start * 1000,
-			EndDate:   end * 1000,
-			Ids:       keyIDs,
-		}},
+		Search: []searchParams{p},
 	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to marshal find request: %w", err)
+		return nil, fmt.Errorf("unable to marshal find request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fetchReportsUrl, bytes.NewReader(jsonBytes))
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("rate limiter: %w", err)
+		}
+		auth := c.creds.get()
+		reports, res, err := c.doFetch(ctx, h, auth, jsonBytes)
+		if err == nil {
+			return reports, nil
+		}
+		if errors.Is(err, ErrUnauthorized) && !refreshed && c.creds.refresher != nil {
+			// Renew the token and retry once
+			refreshed = true
+			logger.Infof("search party token rejected, renewing it")
+			if _, err := c.creds.refresh(ctx, auth); err != nil {
+				return nil, fmt.Errorf("%w: unable to renew token: %w", ErrUnauthorized, err)
+			}
+			attempt--
+			continue
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrUnauthorized) || attempt >= c.maxRetries {
+			return nil, err
+		}
+		if res != nil && !shouldRetry(res.StatusCode) {
+			return nil, err
+		}
+		delay := c.backoff(attempt, res)
+		logger.Warnf("fetch failed (attempt %d/%d), retrying in %s: %v", attempt+1, c.maxRetries+1, delay, err)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// doFetch performs a single HTTP request. The response is returned (with
+// its body already closed) whenever the server answered.
+func (c Client) doFetch(ctx context.Context, h http.Header, auth *Auth, body []byte) ([]Report, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+fetchReportsPath, bytes.NewReader(body))
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to create request: %w", err)
 	}
-	req.Header = h
-	req.SetBasicAuth(c.auth.Dsid, c.auth.SearchPartyToken)
-	res, err := http.DefaultClient.Do(req)
+	req.Header = h.Clone()
+	c.setUserAgent(req)
+	req.SetBasicAuth(auth.Dsid, auth.SearchPartyToken)
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, nil, fmt.Errorf("unable to make request: %w", err)
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return nil, res, fmt.Errorf("%w: status code %d", ErrUnauthorized, res.StatusCode)
+	case res.StatusCode == http.StatusTooManyRequests:
+		return nil, res, fmt.Errorf("%w: status code %d", ErrRateLimited, res.StatusCode)
+	case res.StatusCode != http.StatusOK:
+		return nil, res, fmt.Errorf("unexpected status code: %d", res.StatusCode)
 	}
 	var result FindResult
 	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return nil, nil, fmt.Errorf("unable to decode JSON: %w", err)
+		return nil, res, fmt.Errorf("unable to decode JSON: %w", err)
+	}
+	return result.Results, res, nil
+}
+
+type timeWindow struct {
+	from time.Time
+	to   time.Time
+}
+
+// splitWindow splits [from, to] into consecutive windows of at most maxWindow
+func splitWindow(from, to time.Time, maxWindow time.Duration) []timeWindow {
+	if maxWindow <= 0 || to.Sub(from) <= maxWindow {
+		return []timeWindow{{from: from, to: to}}
+	}
+	var windows []timeWindow
+	for start := from; start.Before(to); start = start.Add(maxWindow) {
+		end := start.Add(maxWindow)
+		if end.After(to) {
+			end = to
+		}
+		windows = append(windows, timeWindow{from: start, to: end})
+	}
+	return windows
+}
+
+// chunk splits ids in slices of at most size elements
+func chunk(ids []string, size int) [][]string {
+	if size <= 0 || len(ids) <= size {
+		return [][]string{ids}
+	}
+	var chunks [][]string
+	for len(ids) > size {
+		chunks = append(chunks, ids[:size])
+		ids = ids[size:]
+	}
+	return append(chunks, ids)
+}
+
+// mergeReports flattens the results of multiple requests, dropping the reports
+// returned more than once (e.g. by overlapping windows)
+func mergeReports(results [][]Report) []Report {
+	seen := make(map[string]struct{})
+	merged := make([]Report, 0)
+	for _, reports := range results {
+		for _, r := range reports {
+			k := r.ID + "|" + r.Payload
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			merged = append(merged, r)
+		}
 	}
-	return result.Results, subKeysMap, nil
+	return merged
 }
 
-func New(auth *Auth, anisetteURL string) *Client {
-	return &Client{auth: auth, anisetteUrl: anisetteURL}
+func (c Client) setUserAgent(req *http.Request) {
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+}
+
+func New(auth *Auth, anisetteURL string, opts ...Option) *Client {
+	c := &Client{
+		creds:       &credentials{auth: auth},
+		baseURL:     defaultBaseURL,
+		httpClient:  http.DefaultClient,
+		batchSize:   defaultBatchSize,
+		maxWindow:   defaultMaxWindow,
+		concurrency: defaultConcurrency,
+		limiter:     rate.NewLimiter(defaultRateLimit, defaultRateBurst),
+		maxRetries:  defaultMaxRetries,
+		baseDelay:   defaultBaseDelay,
+		maxDelay:    defaultMaxDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.anisette == nil {
+		// Can't fail without an identity file
+		c.anisette, _ = NewCachingAnisetteProvider(&RemoteAnisetteProvider{
+			URL:        anisetteURL,
+			HTTPClient: c.httpClient,
+			UserAgent:  c.userAgent,
+		}, "", defaultAnisetteTTL)
+	}
+	return c
 }
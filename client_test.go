@@ -0,0 +1,344 @@
+package searchparty
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/searchparty-keys"
+	"golang.org/x/time/rate"
+
+	"github.com/denysvitali/searchparty-go/model"
+)
+
+func TestClientFindWithBaseURL(t *testing.T) {
+	hashedAdvKey := []byte("hashed-adv-key")
+	hashedAdvKeyB64 := base64.StdEncoding.EncodeToString(hashedAdvKey)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/anisette", func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "test-agent" {
+			t.Errorf("unexpected anisette User-Agent: %q", ua)
+		}
+		_ = json.NewEncoder(w).Encode(AnisetteResponse{XAppleIMD: "md", XAppleIMDM: "mdm"})
+	})
+	mux.HandleFunc(fetchReportsPath, func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "test-agent" {
+			t.Errorf("unexpected fetch User-Agent: %q", ua)
+		}
+		if md := r.Header.Get("X-Apple-I-MD"); md != "md" {
+			t.Errorf("unexpected X-Apple-I-MD: %q", md)
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "dsid" || pass != "token" {
+			t.Errorf("unexpected basic auth: %q %q", user, pass)
+		}
+		var req FindRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("unable to decode request: %v", err)
+		}
+		if len(req.Search) != 1 || len(req.Search[0].Ids) != 1 || req.Search[0].Ids[0] != hashedAdvKeyB64 {
+			t.Errorf("unexpected search params: %+v", req.Search)
+		}
+		_ = json.NewEncoder(w).Encode(FindResult{Results: []Report{{ID: hashedAdvKeyB64, Payload: "cGF5bG9hZA=="}}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(
+		&Auth{Dsid: "dsid", SearchPartyToken: "token"},
+		srv.URL+"/anisette",
+		WithBaseURL(srv.URL),
+		WithHTTPClient(srv.Client()),
+		WithTimeout(5*time.Second),
+		WithUserAgent("test-agent"),
+	)
+	key := &StaticKey{keyID: "test", hashedAdvKey: hashedAdvKey}
+	reports, subKeysMap, err := c.Find(context.Background(), []model.MainKey{key}, 1, time.Now())
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	if _, ok := subKeysMap[reports[0].ID]; !ok {
+		t.Errorf("no subkey for report %s", reports[0].ID)
+	}
+}
+
+func TestSplitWindow(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(50 * time.Hour)
+	windows := splitWindow(from, to, 24*time.Hour)
+	if len(windows) != 3 {
+		t.Fatalf("expected 3 windows, got %d", len(windows))
+	}
+	if !windows[0].from.Equal(from) || !windows[2].to.Equal(to) {
+		t.Errorf("windows do not cover the range: %+v", windows)
+	}
+	for i := 1; i < len(windows); i++ {
+		if !windows[i].from.Equal(windows[i-1].to) {
+			t.Errorf("window %d does not start where window %d ends", i, i-1)
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	chunks := chunk(ids, 2)
+	if len(chunks) != 3 || len(chunks[2]) != 1 {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+	if chunks := chunk(ids, 0); len(chunks) != 1 {
+		t.Errorf("expected a single chunk, got %v", chunks)
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	merged := mergeReports([][]Report{
+		{{ID: "a", Payload: "1"}, {ID: "b", Payload: "2"}},
+		{{ID: "a", Payload: "1"}, {ID: "a", Payload: "3"}},
+	})
+	if len(merged) != 3 {
+		t.Errorf("expected 3 reports, got %d", len(merged))
+	}
+}
+
+func TestFindRangeInvalidRange(t *testing.T) {
+	c := New(&Auth{}, "http://127.0.0.1:0")
+	now := time.Now()
+	_, _, err := c.FindRange(context.Background(), nil, FindOptions{From: now, To: now.Add(-time.Hour)})
+	if err == nil {
+		t.Fatal("expected an error for an inverted time range")
+	}
+	_, _, err = c.FindRange(context.Background(), nil, FindOptions{})
+	if err == nil {
+		t.Fatal("expected an error for a missing start time")
+	}
+}
+
+func newTestDynamicKey(pairingDate time.Time) *DynamicKey {
+	secret := func(b byte) []byte {
+		data := make([]byte, 32)
+		for i := range data {
+			data[i] = b + byte(i)
+		}
+		return data
+	}
+	return &DynamicKey{beacon: &searchpartykeys.Beacon{
+		PairingDate:           pairingDate,
+		PrivateKey:            searchpartykeys.Key{Key: searchpartykeys.KeyData{Data: secret(1)[:28]}},
+		SharedSecret:          searchpartykeys.Key{Key: searchpartykeys.KeyData{Data: secret(2)}},
+		SecondarySharedSecret: searchpartykeys.Key{Key: searchpartykeys.KeyData{Data: secret(3)}},
+		PublicKey:             searchpartykeys.Key{Key: searchpartykeys.KeyData{Data: secret(4)}},
+	}}
+}
+
+func TestFindRangeWindowKeys(t *testing.T) {
+	var mu sync.Mutex
+	var searches []searchParams
+	c := newRetryTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req FindRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("unable to decode request: %v", err)
+		}
+		mu.Lock()
+		searches = append(searches, req.Search...)
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(FindResult{})
+	})
+	c.maxWindow = 24 * time.Hour
+	c.batchSize = 1000
+
+	pairingDate := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := newTestDynamicKey(pairingDate)
+	from := pairingDate.Add(10 * 24 * time.Hour)
+	// Keys used in a day: 96 primary and 1 secondary
+	const perDay = 24*4 + 1
+	for _, days := range []int{1, 2, 4} {
+		searches = nil
+		_, subKeysMap, err := c.FindRange(context.Background(), []model.MainKey{key}, FindOptions{
+			From: from,
+			To:   from.Add(time.Duration(days) * 24 * time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("FindRange failed: %v", err)
+		}
+		if len(searches) != days {
+			t.Fatalf("%d days: expected %d requests, got %d", days, days, len(searches))
+		}
+		seen := map[string]int64{}
+		total := 0
+		for _, p := range searches {
+			for _, id := range p.Ids {
+				if start, ok := seen[id]; ok {
+					t.Fatalf("%d days: %s requested in the windows starting at %d and %d", days, id, start, p.StartDate)
+				}
+				seen[id] = p.StartDate
+			}
+			total += len(p.Ids)
+		}
+		if total != days*perDay || len(subKeysMap) != total {
+			t.Errorf("%d days: expected %d ids, got %d (%d subkeys)", days, days*perDay, total, len(subKeysMap))
+		}
+	}
+
+	// The keys used before the key was lost aren't requested
+	searches = nil
+	if _, _, err := c.FindRange(context.Background(), []model.MainKey{key}, FindOptions{
+		From:   from,
+		To:     from.Add(48 * time.Hour),
+		LostAt: from.Add(24 * time.Hour),
+	}); err != nil {
+		t.Fatalf("FindRange failed: %v", err)
+	}
+	if len(searches) != 1 || len(searches[0].Ids) != perDay {
+		t.Errorf("expected 1 request of %d ids, got %+v", perDay, searches)
+	}
+}
+
+func newRetryTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/anisette", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(AnisetteResponse{})
+	})
+	mux.HandleFunc(fetchReportsPath, handler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return New(
+		&Auth{},
+		srv.URL+"/anisette",
+		WithBaseURL(srv.URL),
+		WithRateLimit(rate.Inf, 1),
+		WithRetry(2, time.Millisecond, 10*time.Millisecond),
+	)
+}
+
+func TestFindRetries(t *testing.T) {
+	var calls atomic.Int32
+	c := newRetryTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(FindResult{})
+	})
+	key := &StaticKey{keyID: "test", hashedAdvKey: []byte("key")}
+	if _, _, err := c.Find(context.Background(), []model.MainKey{key}, 1, time.Time{}); err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls, got %d", calls.Load())
+	}
+}
+
+func TestFindErrors(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		err        error
+		calls      int32
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized, 1},
+		{http.StatusTooManyRequests, ErrRateLimited, 3},
+	}
+	for _, tt := range tests {
+		var calls atomic.Int32
+		c := newRetryTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(tt.statusCode)
+		})
+		key := &StaticKey{keyID: "test", hashedAdvKey: []byte("key")}
+		_, _, err := c.Find(context.Background(), []model.MainKey{key}, 1, time.Time{})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("status %d: expected %v, got %v", tt.statusCode, tt.err, err)
+		}
+		if calls.Load() != tt.calls {
+			t.Errorf("status %d: expected %d calls, got %d", tt.statusCode, tt.calls, calls.Load())
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d, ok := parseRetryAfter("120"); !ok || d != 2*time.Minute {
+		t.Errorf("unexpected result for seconds: %s %v", d, ok)
+	}
+	if _, ok := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); !ok {
+		t.Error("unable to parse HTTP date")
+	}
+	if _, ok := parseRetryAfter("soon"); ok {
+		t.Error("expected invalid value to be rejected")
+	}
+}
+
+type fakeRefresher struct {
+	calls atomic.Int32
+}
+
+func (f *fakeRefresher) Refresh(ctx context.Context, auth *Auth) (*Auth, error) {
+	f.calls.Add(1)
+	renewed := *auth
+	renewed.SearchPartyToken = "renewed"
+	return &renewed, nil
+}
+
+func TestBackoffZeroDelay(t *testing.T) {
+	for _, opt := range []Option{WithRetry(3, 0, 0), WithRetry(3, time.Second, 0), WithRetry(3, 0, time.Second)} {
+		c := New(&Auth{}, "http://127.0.0.1:0", opt)
+		for attempt := range 3 {
+			if d := c.backoff(attempt, nil); d < 0 || d > c.maxDelay {
+				t.Errorf("unexpected delay %s for base %s, max %s", d, c.baseDelay, c.maxDelay)
+			}
+		}
+	}
+}
+
+func TestFindRefreshesToken(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/anisette", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(AnisetteResponse{})
+	})
+	mux.HandleFunc(fetchReportsPath, func(w http.ResponseWriter, r *http.Request) {
+		if _, token, _ := r.BasicAuth(); token != "renewed" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(FindResult{})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	refresher := &fakeRefresher{}
+	c := New(
+		&Auth{Dsid: "dsid", SearchPartyToken: "expired"},
+		srv.URL+"/anisette",
+		WithBaseURL(srv.URL),
+		WithRateLimit(rate.Inf, 1),
+		WithTokenRefresher(refresher),
+		WithAuthFile(authFile),
+	)
+	key := &StaticKey{keyID: "test", hashedAdvKey: []byte("key")}
+	if _, _, err := c.Find(context.Background(), []model.MainKey{key}, 1, time.Time{}); err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if refresher.calls.Load() != 1 {
+		t.Errorf("expected 1 refresh, got %d", refresher.calls.Load())
+	}
+	auth, err := GetAuth(authFile)
+	if err != nil {
+		t.Fatalf("unable to read auth file: %v", err)
+	}
+	if auth.SearchPartyToken != "renewed" {
+		t.Errorf("auth file not updated: %+v", auth)
+	}
+}
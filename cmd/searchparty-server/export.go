@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server"
+	"github.com/denysvitali/searchparty-go/server/export"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+type exportCmd struct {
+	Key    string    `arg:"positional,required" help:"Key ID"`
+	Format string    `arg:"--format,-f" default:"geojson" help:"geojson, gpx or kml"`
+	From   time.Time `arg:"--from" help:"Export the locations found after this time (RFC3339)"`
+	To     time.Time `arg:"--to" help:"Export the locations found before this time (RFC3339), default: now"`
+	Output string    `arg:"--output,-o" help:"Output file, default: stdout"`
+}
+
+func exportHistory(cmd *exportCmd) {
+	ctx := context.Background()
+	format, err := export.ParseFormat(cmd.Format)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	to := cmd.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	st, err := store.Open(ctx, args.Dsn, false)
+	if err != nil {
+		logger.Fatalf("failed to open database: %v", err)
+	}
+	defer st.Close()
+
+	// IDs are base64 encoded, "/" can be replaced with "-"
+	keyID := strings.ReplaceAll(cmd.Key, "-", "/")
+	track, err := server.ExportTrack(ctx, st, keyID, cmd.From, to)
+	if err != nil {
+		logger.Fatalf("failed to get history: %v", err)
+	}
+	if len(track.Points) == 0 {
+		logger.Warnf("no locations found for %s", cmd.Key)
+	}
+
+	out := os.Stdout
+	if cmd.Output != "" {
+		out, err = os.Create(cmd.Output)
+		if err != nil {
+			logger.Fatalf("failed to create output file: %v", err)
+		}
+		defer out.Close()
+	}
+	if err := export.Write(out, format, *track); err != nil {
+		logger.Fatalf("failed to write export: %v", err)
+	}
+}
@@ -1,48 +1,212 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/maps"
 
 	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/gsa"
+	"github.com/denysvitali/searchparty-go/server"
+	"github.com/denysvitali/searchparty-go/server/battery"
+	"github.com/denysvitali/searchparty-go/server/events"
+	"github.com/denysvitali/searchparty-go/server/geofence"
+	"github.com/denysvitali/searchparty-go/server/mqtt"
+	"github.com/denysvitali/searchparty-go/server/owntracks"
+	"github.com/denysvitali/searchparty-go/server/store"
+	"github.com/denysvitali/searchparty-go/server/traccar"
+	"github.com/denysvitali/searchparty-go/server/webhook"
 	"github.com/denysvitali/searchparty-go/service"
 )
 
 var args struct {
-	AnisetteURL         string `arg:"--anisette-url,-A" default:"http://localhost:6969" help:"Anisette URL"`
-	ListenAddr          string `arg:"--listen-addr,-l" default:"127.0.0.1:8500" help:"Listen address"`
-	BeaconStorePassword string `arg:"--beacon-store-password,env:BEACON_STORE_PASSWORD,required" help:"Beacon store password (in hex)"`
-	Dsn                 string `arg:"--dsn" default:"host=localhost port=5438 user=searchparty password=searchparty dbname=searchparty sslmode=disable binary_parameters=yes" help:"DSN for the database"`
-	LogLevel            string `arg:"--log-level" default:"info" help:"Log level"`
+	AnisetteURL         string        `arg:"--anisette-url,-A" default:"http://localhost:6969" help:"Anisette URL"`
+	AnisetteIdentity    string        `arg:"--anisette-identity" default:"anisette-identity.json" help:"File storing the anisette device identity"`
+	AnisetteV3          bool          `arg:"--anisette-v3" help:"Use the anisette v3 protocol, provisioning the device locally"`
+	AnisetteState       string        `arg:"--anisette-state" default:"anisette-v3.json" help:"File storing the anisette v3 provisioning state"`
+	AppleCACert         string        `arg:"--apple-ca-cert" help:"PEM file with additional CAs trusted when talking to gsa.apple.com"`
+	AuthFile            string        `arg:"--auth-file" default:"auth.json" help:"File with the search party credentials"`
+	AccountsDir         string        `arg:"--accounts-dir" help:"Directory with one auth file (<name>.json) per Apple ID, overrides --auth-file"`
+	BeaconsDir          string        `arg:"--beacons-dir" default:"./beacons/" help:"Directory with the beacon keys"`
+	ListenAddr          string        `arg:"--listen-addr,-l" default:"127.0.0.1:8500" help:"Listen address"`
+	NoREST              bool          `arg:"--no-rest" help:"Don't serve the REST API (/api/v1) next to the gRPC gateway"`
+	PollMinInterval     time.Duration `arg:"--poll-min-interval" default:"15m" help:"Interval at which lost or moving keys are polled in the background, 0 disables polling"`
+	PollMaxInterval     time.Duration `arg:"--poll-max-interval" default:"6h" help:"Longest interval between two background polls of a key"`
+	BeaconStorePassword string        `arg:"--beacon-store-password,env:BEACON_STORE_PASSWORD" help:"Beacon store password (in hex), required unless running a subcommand"`
+	Dsn                 string        `arg:"--dsn" default:"host=localhost port=5438 user=searchparty password=searchparty dbname=searchparty sslmode=disable binary_parameters=yes" help:"DSN of the PostgreSQL database, or sqlite://<path> for a SQLite one"`
+	RetentionRawFor     time.Duration `arg:"--retention-raw-for" help:"Keep every location for this long, then downsample them (e.g. 720h), 0 disables downsampling"`
+	RetentionBucket     time.Duration `arg:"--retention-bucket" default:"1h" help:"Downsampling interval, the location with the highest confidence is kept"`
+	RetentionDelete     time.Duration `arg:"--retention-delete-after" help:"Delete the locations older than this, 0 keeps them forever"`
+	RetentionDropRaw    bool          `arg:"--retention-drop-original-content" help:"Remove the encrypted payload of the stored locations"`
+	RetentionDryRun     bool          `arg:"--retention-dry-run" help:"Only log what the retention policy would remove"`
+	RetentionInterval   time.Duration `arg:"--retention-interval" default:"24h" help:"Interval at which the retention policy is applied"`
+	WebhookMaxAttempts  int           `arg:"--webhook-max-attempts" default:"8" help:"Attempts before a webhook delivery is marked as failed"`
+	MQTTBroker          string        `arg:"--mqtt-broker" help:"MQTT broker URL (e.g. tcp://localhost:1883) to publish the new locations to"`
+	MQTTClientID        string        `arg:"--mqtt-client-id" default:"searchparty-go" help:"MQTT client id"`
+	MQTTUsername        string        `arg:"--mqtt-username" help:"MQTT username"`
+	MQTTPassword        string        `arg:"--mqtt-password,env:MQTT_PASSWORD" help:"MQTT password"`
+	MQTTTopic           string        `arg:"--mqtt-topic" default:"searchparty/{key}/location" help:"Topic of the locations of a key, {key} is replaced with the key id"`
+	MQTTStatusTopic     string        `arg:"--mqtt-status-topic" default:"searchparty/status" help:"Topic of the online/offline status"`
+	MQTTDiscoveryPrefix string        `arg:"--mqtt-discovery-prefix" default:"homeassistant" help:"Home Assistant discovery prefix, empty disables discovery"`
+	MQTTQoS             byte          `arg:"--mqtt-qos" default:"1" help:"MQTT QoS of the published messages"`
+	MQTTNoRetain        bool          `arg:"--mqtt-no-retain" help:"Don't retain the last location of every key"`
+	OwnTracksURL        string        `arg:"--owntracks-url" help:"OwnTracks Recorder HTTP endpoint (e.g. http://localhost:8083/pub) to push the new locations to"`
+	OwnTracksUser       string        `arg:"--owntracks-user" default:"searchparty" help:"OwnTracks user the keys belong to"`
+	OwnTracksUsername   string        `arg:"--owntracks-username" help:"Username of the Recorder endpoint (basic auth)"`
+	OwnTracksPassword   string        `arg:"--owntracks-password,env:OWNTRACKS_PASSWORD" help:"Password of the Recorder endpoint (basic auth)"`
+	TraccarURL          string        `arg:"--traccar-url" help:"OsmAnd endpoint of a Traccar server (e.g. http://localhost:5055) to forward the locations of the enabled keys to"`
+	TraccarIDSource     string        `arg:"--traccar-id" default:"key" help:"Traccar device id: key (cleaned key id) or alias"`
+	TraccarMaxAttempts  int           `arg:"--traccar-max-attempts" default:"8" help:"Attempts before a location forwarded to Traccar is marked as failed"`
+	NoAutoMigrate       bool          `arg:"--no-auto-migrate" help:"Don't apply the pending database migrations at startup, see the migrate command"`
+	LogLevel            string        `arg:"--log-level" default:"info" help:"Log level"`
+
+	Migrate   *migrateCmd   `arg:"subcommand:migrate" help:"Show or change the database schema version"`
+	Export    *exportCmd    `arg:"subcommand:export" help:"Export the location history of a key as GeoJSON, GPX or KML"`
+	OwnTracks *owntracksCmd `arg:"subcommand:owntracks-export" help:"Export the location history in the OwnTracks Recorder .rec format"`
 }
 var logger = logrus.StandardLogger()
 
 func main() {
-	arg.MustParse(&args)
+	p := arg.MustParse(&args)
 	setLogLevel(args.LogLevel)
 
-	auth, err := searchparty.GetAuth("auth.json")
-	if err != nil {
-		logger.Fatalf("failed to get auth: %v", err)
+	switch {
+	case args.Migrate != nil:
+		migrate(args.Migrate)
+	case args.Export != nil:
+		exportHistory(args.Export)
+	case args.OwnTracks != nil:
+		exportOwnTracks(args.OwnTracks)
+	default:
+		if args.BeaconStorePassword == "" {
+			p.Fail("--beacon-store-password is required")
+		}
+		serve()
 	}
-	beaconStorePwdBytes, err := hex.DecodeString(args.BeaconStorePassword)
+}
 
+func serve() {
+	beaconStorePwdBytes, err := hex.DecodeString(args.BeaconStorePassword)
 	if err != nil {
 		logger.Fatalf("failed to decode beacon store password: %v", err)
 	}
 
-	s, err := service.New(auth, args.AnisetteURL, args.Dsn, beaconStorePwdBytes)
+	finder, err := newFinder()
+	if err != nil {
+		logger.Fatalf("failed to create client: %v", err)
+	}
+
+	keyMap, err := searchparty.LoadKeyMap(args.BeaconsDir, beaconStorePwdBytes)
+	if err != nil {
+		logger.Fatalf("failed to load keys: %v", err)
+	}
+	st, err := store.Open(context.Background(), args.Dsn, !args.NoAutoMigrate)
 	if err != nil {
-		logger.Fatalf("failed to create server: %v", err)
+		logger.Fatalf("failed to open database: %v", err)
+	}
+	defer st.Close()
+	// Every new location is checked against the geofences and the battery
+	// level of the previous one, and sent to the webhooks
+	geofences := geofence.New(st)
+	batteries := battery.New(st)
+	webhooks := webhook.New(st)
+	webhooks.MaxAttempts = args.WebhookMaxAttempts
+	geofences.OnEvent(webhooks.GeofenceEvent)
+	batteries.OnLow(webhooks.BatteryLow)
+	broker := events.NewBroker()
+	hooks := []store.LocationHook{geofences, batteries, webhooks, broker}
+	if args.MQTTBroker != "" {
+		publisher := newMQTTPublisher(st, maps.Keys(keyMap))
+		defer publisher.Close()
+		hooks = append(hooks, publisher)
+	}
+	if args.OwnTracksURL != "" {
+		pusher, err := owntracks.NewPusher(owntracks.Config{
+			URL:      args.OwnTracksURL,
+			User:     args.OwnTracksUser,
+			Username: args.OwnTracksUsername,
+			Password: args.OwnTracksPassword,
+		}, st)
+		if err != nil {
+			logger.Fatalf("invalid owntracks configuration: %v", err)
+		}
+		go pusher.Run(context.Background())
+		hooks = append(hooks, pusher)
+	}
+	var forwarder *traccar.Forwarder
+	if args.TraccarURL != "" {
+		forwarder, err = traccar.New(traccar.Config{URL: args.TraccarURL, IDSource: args.TraccarIDSource}, st)
+		if err != nil {
+			logger.Fatalf("invalid traccar configuration: %v", err)
+		}
+		forwarder.MaxAttempts = args.TraccarMaxAttempts
+		go forwarder.Run(context.Background())
+		hooks = append(hooks, forwarder)
+	}
+	st = store.WithHooks(st, hooks...)
+	go webhooks.Run(context.Background())
+
+	// The REST API and the gRPC gateway share the database and the keys
+	restServer := server.New(finder, st, keyMap)
+	restServer.SetWebhooks(webhooks)
+	restServer.OnKeyLost(webhooks.KeyLost)
+	restServer.SetEvents(broker)
+	if forwarder != nil {
+		restServer.SetTraccar(forwarder)
+	}
+	if args.PollMinInterval > 0 {
+		go server.NewScheduler(restServer, args.PollMinInterval, args.PollMaxInterval).Run(context.Background())
+	}
+	policy := server.RetentionPolicy{
+		RawFor:              args.RetentionRawFor,
+		Bucket:              args.RetentionBucket,
+		DeleteAfter:         args.RetentionDelete,
+		DropOriginalContent: args.RetentionDropRaw,
+		DryRun:              args.RetentionDryRun,
+	}
+	if policy.Enabled() {
+		go server.NewRetention(restServer, policy).Run(context.Background(), args.RetentionInterval)
 	}
+	var rest http.Handler
+	if !args.NoREST {
+		rest = restServer.Handler()
+	}
+	s := service.New(finder, st, keyMap)
+	s.SetEvents(broker)
 	logger.Infof("Listening on %s", args.ListenAddr)
-	if err := s.Start("127.0.0.1:8084", args.ListenAddr); err != nil {
+	if err := s.Start("127.0.0.1:8084", args.ListenAddr, rest); err != nil {
 		logger.Fatalf("start server: %v", err)
 	}
 }
 
+func newMQTTPublisher(st store.Store, keyIDs []string) *mqtt.Publisher {
+	publisher, err := mqtt.New(mqtt.Config{
+		Broker:          args.MQTTBroker,
+		ClientID:        args.MQTTClientID,
+		Username:        args.MQTTUsername,
+		Password:        args.MQTTPassword,
+		Topic:           args.MQTTTopic,
+		StatusTopic:     args.MQTTStatusTopic,
+		DiscoveryPrefix: args.MQTTDiscoveryPrefix,
+		QoS:             args.MQTTQoS,
+		Retain:          !args.MQTTNoRetain,
+	}, st, keyIDs)
+	if err != nil {
+		logger.Fatalf("invalid mqtt configuration: %v", err)
+	}
+	if err := publisher.Connect(); err != nil {
+		logger.Fatalf("failed to connect to %s: %v", args.MQTTBroker, err)
+	}
+	return publisher
+}
+
 func setLogLevel(level string) {
 	l, err := logrus.ParseLevel(level)
 	if err != nil {
@@ -50,3 +214,52 @@ func setLogLevel(level string) {
 	}
 	logger.SetLevel(l)
 }
+
+// newFinder returns a client for the auth file, or a pool of clients if
+// an accounts directory is set
+func newFinder() (searchparty.Finder, error) {
+	if args.AccountsDir == "" {
+		auth, err := searchparty.GetAuth(args.AuthFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get auth: %w", err)
+		}
+		return newClient(auth, args.AuthFile, args.AnisetteIdentity, args.AnisetteState)
+	}
+	// Every account has its own anisette identity, stored next to its auth file
+	return searchparty.LoadAccountPool(args.AccountsDir, func(name string, auth *searchparty.Auth, authFile string) (*searchparty.Client, error) {
+		return newClient(auth, authFile,
+			filepath.Join(args.AccountsDir, name+".anisette"),
+			filepath.Join(args.AccountsDir, name+".anisette-v3"),
+		)
+	})
+}
+
+func newClient(auth *searchparty.Auth, authFile string, identityFile string, stateFile string) (*searchparty.Client, error) {
+	appleHTTPClient, err := searchparty.NewAppleHTTPClient(args.AppleCACert)
+	if err != nil {
+		return nil, err
+	}
+	anisetteProvider, err := newAnisetteProvider(appleHTTPClient, identityFile, stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create anisette provider: %w", err)
+	}
+	return searchparty.New(auth, args.AnisetteURL,
+		searchparty.WithAnisetteProvider(anisetteProvider),
+		searchparty.WithTokenRefresher(gsa.New(anisetteProvider, gsa.WithHTTPClient(appleHTTPClient))),
+		searchparty.WithAuthFile(authFile),
+	), nil
+}
+
+func newAnisetteProvider(appleHTTPClient *http.Client, identityFile string, stateFile string) (searchparty.AnisetteProvider, error) {
+	var source searchparty.AnisetteSource = &searchparty.RemoteAnisetteProvider{URL: args.AnisetteURL}
+	if args.AnisetteV3 {
+		source = &searchparty.AnisetteV3Provider{
+			URL:             args.AnisetteURL,
+			StateFile:       stateFile,
+			AppleHTTPClient: appleHTTPClient,
+		}
+		// The identity is derived from the provisioning state
+		identityFile = ""
+	}
+	return searchparty.NewCachingAnisetteProvider(source, identityFile, 0)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+type migrateCmd struct {
+	Action string `arg:"positional" default:"status" help:"status, up or down"`
+	To     *int   `arg:"--to" help:"Schema version to migrate to (default: latest for up, previous for down)"`
+}
+
+func migrate(cmd *migrateCmd) {
+	ctx := context.Background()
+	m, err := store.NewMigrator(args.Dsn)
+	if err != nil {
+		logger.Fatalf("failed to open database: %v", err)
+	}
+	defer m.Close()
+
+	version, err := m.Version(ctx)
+	if err != nil {
+		logger.Fatalf("failed to get schema version: %v", err)
+	}
+
+	switch cmd.Action {
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			logger.Fatalf("failed to get migrations: %v", err)
+		}
+		fmt.Printf("schema version: %d (latest: %d)\n", version, m.Latest())
+		for _, s := range status {
+			applied := " "
+			if s.Applied {
+				applied = "x"
+			}
+			fmt.Printf("[%s] %04d %s\n", applied, s.Version, s.Name)
+		}
+		return
+	case "up":
+		target := m.Latest()
+		if cmd.To != nil {
+			target = *cmd.To
+		}
+		if target < version {
+			logger.Fatalf("schema is at version %d, use down to revert migrations", version)
+		}
+		err = m.To(ctx, target)
+	case "down":
+		target := version - 1
+		if cmd.To != nil {
+			target = *cmd.To
+		}
+		if target > version {
+			logger.Fatalf("schema is at version %d, use up to apply migrations", version)
+		}
+		if target < 0 {
+			target = 0
+		}
+		err = m.To(ctx, target)
+	default:
+		logger.Fatalf("unknown action %q, expected status, up or down", cmd.Action)
+	}
+	if err != nil {
+		logger.Fatalf("migration failed: %v", err)
+	}
+	version, err = m.Version(ctx)
+	if err != nil {
+		logger.Fatalf("failed to get schema version: %v", err)
+	}
+	logger.Infof("schema is at version %d", version)
+}
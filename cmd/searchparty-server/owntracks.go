@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/owntracks"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+type owntracksCmd struct {
+	Keys   []string  `arg:"positional" help:"Key IDs, default: all the keys with locations"`
+	User   string    `arg:"--user" default:"searchparty" help:"OwnTracks user the keys belong to"`
+	From   time.Time `arg:"--from" help:"Export the locations found after this time (RFC3339)"`
+	To     time.Time `arg:"--to" help:"Export the locations found before this time (RFC3339), default: now"`
+	Output string    `arg:"--output,-o,required" help:"Recorder storage directory, the locations are merged into rec/<user>/<device>/"`
+}
+
+func exportOwnTracks(cmd *owntracksCmd) {
+	ctx := context.Background()
+	to := cmd.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	st, err := store.Open(ctx, args.Dsn, false)
+	if err != nil {
+		logger.Fatalf("failed to open database: %v", err)
+	}
+	defer st.Close()
+
+	keyIDs := make([]string, 0, len(cmd.Keys))
+	for _, k := range cmd.Keys {
+		// IDs are base64 encoded, "/" can be replaced with "-"
+		keyIDs = append(keyIDs, strings.ReplaceAll(k, "-", "/"))
+	}
+	if len(keyIDs) == 0 {
+		keyIDs, err = st.LocationKeyIDs(ctx)
+		if err != nil {
+			logger.Fatalf("failed to list keys: %v", err)
+		}
+	}
+
+	n, err := owntracks.ExportRec(ctx, st, cmd.Output, cmd.User, keyIDs, cmd.From, to)
+	if err != nil {
+		logger.Fatalf("failed to export locations: %v", err)
+	}
+	logger.Infof("exported %d locations of %d keys to %s", n, len(keyIDs), cmd.Output)
+}
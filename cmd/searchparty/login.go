@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/gsa"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+type loginCmd struct {
+	Username string `arg:"positional,required" help:"Apple ID"`
+	Password string `arg:"env:APPLE_ID_PASSWORD" help:"Apple ID password, asked interactively if not set"`
+	SMS      bool   `arg:"--sms" help:"Receive the 2FA code via SMS instead of a trusted device"`
+}
+
+func login(cmd *loginCmd) {
+	anisetteProvider, err := newAnisetteProvider()
+	if err != nil {
+		logger.Fatalf("failed to create anisette provider: %v", err)
+	}
+	appleHTTPClient, err := searchparty.NewAppleHTTPClient(args.AppleCACert)
+	if err != nil {
+		logger.Fatalf("failed to create HTTP client: %v", err)
+	}
+
+	password := cmd.Password
+	if password == "" {
+		password, err = readPassword("Password: ")
+		if err != nil {
+			logger.Fatalf("failed to read password: %v", err)
+		}
+	}
+
+	c := gsa.New(anisetteProvider, gsa.WithHTTPClient(appleHTTPClient))
+	auth, err := c.Login(context.Background(), cmd.Username, password, promptCode, cmd.SMS)
+	if err != nil {
+		logger.Fatalf("failed to log in: %v", err)
+	}
+	if err := searchparty.SaveAuth(args.AuthFile, auth); err != nil {
+		logger.Fatalf("failed to save auth: %v", err)
+	}
+	logger.Infof("credentials stored in %s", args.AuthFile)
+}
+
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		return string(b), err
+	}
+	line, err := stdin.ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+func promptCode(method gsa.SecondFactorMethod) (string, error) {
+	fmt.Fprintf(os.Stderr, "Enter the 2FA code sent via %s: ", method)
+	line, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -12,24 +12,60 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/gsa"
 	"github.com/denysvitali/searchparty-go/model"
 )
 
 var logger = logrus.StandardLogger()
 
 var args struct {
-	AnisetteURL         string `arg:"--anisette-url,-A" default:"http://localhost:6969" help:"Anisette URL"`
-	BeaconStorePassword string `arg:"--beacon-store-password,env:BEACON_STORE_PASSWORD,required" help:"Beacon store password"`
+	AnisetteURL         string    `arg:"--anisette-url,-A" default:"http://localhost:6969" help:"Anisette URL"`
+	AnisetteIdentity    string    `arg:"--anisette-identity" default:"anisette-identity.json" help:"File storing the anisette device identity"`
+	AnisetteV3          bool      `arg:"--anisette-v3" help:"Use the anisette v3 protocol, provisioning the device locally"`
+	AnisetteState       string    `arg:"--anisette-state" default:"anisette-v3.json" help:"File storing the anisette v3 provisioning state"`
+	AppleCACert         string    `arg:"--apple-ca-cert" help:"PEM file with additional CAs trusted when talking to gsa.apple.com"`
+	AuthFile            string    `arg:"--auth-file" default:"auth.json" help:"File with the search party credentials"`
+	BeaconStorePassword string    `arg:"--beacon-store-password,env:BEACON_STORE_PASSWORD" help:"Beacon store password (required unless running a subcommand)"`
+	Hours               int       `arg:"--hours" default:"2" help:"Amount of hours to look back, ignored if --from is set"`
+	From                time.Time `arg:"--from" help:"Start of the time range (RFC3339)"`
+	To                  time.Time `arg:"--to" help:"End of the time range (RFC3339), defaults to now"`
+	LostAt              time.Time `arg:"--lost-at" help:"Time the keys were marked as lost (RFC3339), defaults to the start of the time range"`
+
+	Login *loginCmd `arg:"subcommand:login" help:"Log in with an Apple ID and store the credentials in the auth file"`
 }
 
 func main() {
-	arg.MustParse(&args)
+	p := arg.MustParse(&args)
+
+	switch {
+	case args.Login != nil:
+		login(args.Login)
+	default:
+		if args.BeaconStorePassword == "" {
+			p.Fail("--beacon-store-password is required")
+		}
+		find()
+	}
+}
 
-	auth, err := searchparty.GetAuth("auth.json")
+func find() {
+	auth, err := searchparty.GetAuth(args.AuthFile)
 	if err != nil {
 		logger.Fatalf("failed to get auth: %v", err)
 	}
-	c := searchparty.New(auth, args.AnisetteURL)
+	anisetteProvider, err := newAnisetteProvider()
+	if err != nil {
+		logger.Fatalf("failed to create anisette provider: %v", err)
+	}
+	appleHTTPClient, err := searchparty.NewAppleHTTPClient(args.AppleCACert)
+	if err != nil {
+		logger.Fatalf("failed to create HTTP client: %v", err)
+	}
+	c := searchparty.New(auth, args.AnisetteURL,
+		searchparty.WithAnisetteProvider(anisetteProvider),
+		searchparty.WithTokenRefresher(gsa.New(anisetteProvider, gsa.WithHTTPClient(appleHTTPClient))),
+		searchparty.WithAuthFile(args.AuthFile),
+	)
 
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -51,10 +87,21 @@ func main() {
 		keyMap[k.ID()] = k
 	}
 
-	lostAt := time.Now()
+	from := args.From
+	if from.IsZero() {
+		to := args.To
+		if to.IsZero() {
+			to = time.Now()
+		}
+		from = to.Add(-time.Duration(args.Hours) * time.Hour)
+	}
 
 	ctx := context.Background()
-	reports, subKeysMap, err := c.Find(ctx, keys, 2, lostAt)
+	reports, subKeysMap, err := c.FindRange(ctx, keys, searchparty.FindOptions{
+		From:   from,
+		To:     args.To,
+		LostAt: args.LostAt,
+	})
 	if err != nil {
 		logger.Errorf("failed to find reports: %v", err)
 	}
@@ -91,3 +138,22 @@ func main() {
 		}
 	}
 }
+
+func newAnisetteProvider() (searchparty.AnisetteProvider, error) {
+	var source searchparty.AnisetteSource = &searchparty.RemoteAnisetteProvider{URL: args.AnisetteURL}
+	identityFile := args.AnisetteIdentity
+	if args.AnisetteV3 {
+		appleHTTPClient, err := searchparty.NewAppleHTTPClient(args.AppleCACert)
+		if err != nil {
+			return nil, err
+		}
+		source = &searchparty.AnisetteV3Provider{
+			URL:             args.AnisetteURL,
+			StateFile:       args.AnisetteState,
+			AppleHTTPClient: appleHTTPClient,
+		}
+		// The identity is derived from the provisioning state
+		identityFile = ""
+	}
+	return searchparty.NewCachingAnisetteProvider(source, identityFile, 0)
+}
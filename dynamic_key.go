@@ -71,9 +71,17 @@ func CalculateKeyRotation(from, to, initialTime time.Time, rotationDuration time
 	return amountKeys, int(offset)
 }
 
+// GetSubKeys returns the advertisement keys used between from and to. The
+// keys used before lostAt aren't reported, so they're skipped.
 func (d *DynamicKey) GetSubKeys(from time.Time, to time.Time, lostAt time.Time) (subKeys []model.SubKey, err error) {
-	amountPrimary, offsetPrimary := CalculateKeyRotation(lostAt, to, d.beacon.PairingDate, primaryRotation)
-	amountSecondary, offsetSecondary := CalculateKeyRotation(lostAt, to, d.beacon.PairingDate, secondaryRotation)
+	if lostAt.After(from) {
+		from = lostAt
+	}
+	if !from.Before(to) {
+		return nil, nil
+	}
+	amountPrimary, offsetPrimary := CalculateKeyRotation(from, to, d.beacon.PairingDate, primaryRotation)
+	amountSecondary, offsetSecondary := CalculateKeyRotation(from, to, d.beacon.PairingDate, secondaryRotation)
 
 	logger.Debugf("Primary: %d, Secondary: %d", amountPrimary, amountSecondary)
 	logger.Debugf("Primary offset: %d, Secondary offset: %d", offsetPrimary, offsetSecondary)
@@ -0,0 +1,282 @@
+// Package gsa implements the Apple ID (GrandSlam) authentication used to
+// obtain the search party token.
+package gsa
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/pbkdf2"
+	"howett.net/plist"
+
+	"github.com/denysvitali/searchparty-go"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "gsa")
+
+const (
+	defaultBaseURL  = "https://gsa.apple.com"
+	defaultSetupURL = "https://setup.icloud.com"
+
+	gsaPath = "/grandslam/GsService2"
+
+	petToken = "com.apple.gs.idms.pet"
+
+	clientInfo = "<MacBookPro18,3> <Mac OS X;13.4.1;22F8> <com.apple.AOSKit/282 (com.apple.dt.Xcode/3594.4.19)>"
+	userAgent  = "akd/1.0 CFNetwork/978.0.7 Darwin/18.7.0"
+)
+
+var (
+	// ErrSecondFactorRequired is returned by Authenticate when the account requires 2FA
+	ErrSecondFactorRequired = errors.New("second factor required")
+	// ErrUnsupportedProtocol is returned when the server asks for an unknown password protocol
+	ErrUnsupportedProtocol = errors.New("unsupported password protocol")
+)
+
+// Client talks to the GSA (gsa.apple.com) and iCloud setup endpoints
+type Client struct {
+	anisette   searchparty.AnisetteProvider
+	httpClient *http.Client
+	baseURL    string
+	setupURL   string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the *http.Client used for the requests to Apple, see searchparty.NewAppleHTTPClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithBaseURL sets the GSA base URL (default: https://gsa.apple.com)
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithSetupURL sets the iCloud setup base URL (default: https://setup.icloud.com)
+func WithSetupURL(setupURL string) Option {
+	return func(c *Client) {
+		c.setupURL = strings.TrimSuffix(setupURL, "/")
+	}
+}
+
+func New(anisette searchparty.AnisetteProvider, opts ...Option) *Client {
+	c := &Client{
+		anisette:   anisette,
+		httpClient: http.DefaultClient,
+		baseURL:    defaultBaseURL,
+		setupURL:   defaultSetupURL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Session is the result of a successful GSA authentication
+type Session struct {
+	// ADSID is the alternate DSID of the account
+	ADSID string `plist:"adsid"`
+	// IdmsToken is used to authenticate the 2FA requests
+	IdmsToken string `plist:"GsIdmsToken"`
+	// Tokens are the tokens issued by GSA, indexed by name
+	Tokens map[string]Token `plist:"t"`
+}
+
+type Token struct {
+	Token  string `plist:"token"`
+	Expiry int64  `plist:"expiry"`
+}
+
+// PET returns the password equivalent token, used in place of the password
+// to obtain the iCloud delegates
+func (s *Session) PET() string {
+	return s.Tokens[petToken].Token
+}
+
+type gsaStatus struct {
+	ErrorCode    int    `plist:"ec"`
+	ErrorMessage string `plist:"em"`
+	AuthType     string `plist:"au"`
+}
+
+type gsaResponse struct {
+	Status     gsaStatus `plist:"Status"`
+	Protocol   string    `plist:"sp"`
+	Salt       []byte    `plist:"s"`
+	Iterations int       `plist:"i"`
+	B          []byte    `plist:"B"`
+	Cookie     string    `plist:"c"`
+	M2         []byte    `plist:"M2"`
+	SPD        []byte    `plist:"spd"`
+}
+
+// Authenticate performs the SRP authentication. If the account requires a
+// second factor, the (partial) session is returned along with
+// ErrSecondFactorRequired and the authentication must be repeated once the
+// second factor has been validated.
+func (c *Client) Authenticate(ctx context.Context, username string, password string) (*Session, string, error) {
+	srp, err := newSRPClient(username)
+	if err != nil {
+		return nil, "", err
+	}
+	init, err := c.request(ctx, map[string]any{
+		"A2k": srp.publicKey(),
+		"ps":  []string{"s2k", "s2k_fo"},
+		"u":   username,
+		"o":   "init",
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("init: %w", err)
+	}
+	if init.Protocol != "s2k" && init.Protocol != "s2k_fo" {
+		return nil, "", fmt.Errorf("%w: %q", ErrUnsupportedProtocol, init.Protocol)
+	}
+
+	m1, err := srp.processChallenge(derivePassword(password, init.Salt, init.Iterations, init.Protocol), init.Salt, init.B)
+	if err != nil {
+		return nil, "", err
+	}
+	complete, err := c.request(ctx, map[string]any{
+		"c":  init.Cookie,
+		"M1": m1,
+		"u":  username,
+		"o":  "complete",
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("complete: %w", err)
+	}
+	if err := srp.verifySession(complete.M2); err != nil {
+		return nil, "", err
+	}
+
+	spd, err := decryptSPD(srp.K, complete.SPD)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to decrypt session data: %w", err)
+	}
+	var session Session
+	if _, err := plist.Unmarshal(spd, &session); err != nil {
+		return nil, "", fmt.Errorf("unable to decode session data: %w", err)
+	}
+
+	switch complete.Status.AuthType {
+	case authTypeTrustedDevice, authTypeSMS:
+		return &session, complete.Status.AuthType, ErrSecondFactorRequired
+	case "":
+		return &session, "", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported authentication type %q", complete.Status.AuthType)
+	}
+}
+
+// request sends a GSA request and returns the decoded response
+func (c *Client) request(ctx context.Context, params map[string]any) (*gsaResponse, error) {
+	h, err := c.anisette.Headers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get anisette headers: %w", err)
+	}
+	cpd := map[string]any{
+		"bootstrap": true,
+		"icscrec":   true,
+		"pbe":       false,
+		"prkgen":    true,
+		"svct":      "iCloud",
+	}
+	for k, v := range h {
+		if len(v) > 0 {
+			cpd[k] = v[0]
+		}
+	}
+	params["cpd"] = cpd
+
+	body, err := plist.Marshal(map[string]any{
+		"Header":  map[string]any{"Version": "1.0.1"},
+		"Request": params,
+	}, plist.XMLFormat)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+gsaPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/x-xml-plist")
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Mme-Client-Info", clientInfo)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Response gsaResponse `plist:"Response"`
+	}
+	if _, err := plist.Unmarshal(b, &response); err != nil {
+		return nil, fmt.Errorf("unable to decode response: %w", err)
+	}
+	if response.Response.Status.ErrorCode != 0 {
+		return nil, fmt.Errorf("gsa error %d: %s", response.Response.Status.ErrorCode, response.Response.Status.ErrorMessage)
+	}
+	return &response.Response, nil
+}
+
+// derivePassword derives the SRP password from the user's password
+func derivePassword(password string, salt []byte, iterations int, protocol string) []byte {
+	p := sha256.Sum256([]byte(password))
+	key := p[:]
+	if protocol == "s2k_fo" {
+		key = []byte(hex.EncodeToString(key))
+	}
+	return pbkdf2.Key(key, salt, iterations, 32, sha256.New)
+}
+
+// sessionKey derives a key from the SRP session key
+func sessionKey(k []byte, name string) []byte {
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(name))
+	return mac.Sum(nil)
+}
+
+// decryptSPD decrypts the session data (AES-256-CBC with PKCS#7 padding)
+func decryptSPD(k []byte, data []byte) ([]byte, error) {
+	key := sessionKey(k, "extra data key:")
+	iv := sessionKey(k, "extra data iv:")[:aes.BlockSize]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid data length")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(out) {
+		return nil, errors.New("invalid padding")
+	}
+	return out[:len(out)-padding], nil
+}
@@ -0,0 +1,175 @@
+package gsa
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"howett.net/plist"
+)
+
+type fakeAnisette struct{}
+
+func (fakeAnisette) Headers(ctx context.Context) (http.Header, error) {
+	return http.Header{"X-Apple-I-MD": []string{"otp"}}, nil
+}
+
+// fakeGSA implements the server side of the GSA SRP authentication
+type fakeGSA struct {
+	t        *testing.T
+	username string
+	password string
+	salt     []byte
+	authType string
+
+	b, B, A *big.Int
+}
+
+func (f *fakeGSA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req struct {
+		Request map[string]any `plist:"Request"`
+	}
+	if _, err := plist.Unmarshal(body, &req); err != nil {
+		f.t.Fatalf("unable to decode request: %v", err)
+	}
+	if _, ok := req.Request["cpd"]; !ok {
+		f.t.Errorf("cpd missing from request")
+	}
+	var res map[string]any
+	switch req.Request["o"] {
+	case "init":
+		res = f.init(req.Request["A2k"].([]byte))
+	case "complete":
+		res = f.complete(req.Request["M1"].([]byte))
+	}
+	b, _ := plist.Marshal(map[string]any{"Response": res}, plist.XMLFormat)
+	_, _ = w.Write(b)
+}
+
+func (f *fakeGSA) verifier() *big.Int {
+	p := derivePassword(f.password, f.salt, 1000, "s2k")
+	x := new(big.Int).SetBytes(srpHash(f.salt, srpHash([]byte(":"), p)))
+	return new(big.Int).Exp(srpG, x, srpN)
+}
+
+func (f *fakeGSA) init(a []byte) map[string]any {
+	f.A = new(big.Int).SetBytes(a)
+	bBytes := make([]byte, 32)
+	_, _ = rand.Read(bBytes)
+	f.b = new(big.Int).SetBytes(bBytes)
+	k := new(big.Int).SetBytes(srpHash(srpN.Bytes(), srpPad(srpG)))
+	f.B = new(big.Int).Mul(k, f.verifier())
+	f.B.Add(f.B, new(big.Int).Exp(srpG, f.b, srpN))
+	f.B.Mod(f.B, srpN)
+	return map[string]any{
+		"Status": map[string]any{"ec": 0},
+		"sp":     "s2k",
+		"s":      f.salt,
+		"i":      1000,
+		"B":      f.B.Bytes(),
+		"c":      "cookie",
+	}
+}
+
+func (f *fakeGSA) complete(m1 []byte) map[string]any {
+	u := new(big.Int).SetBytes(srpHash(srpPad(f.A), srpPad(f.B)))
+	S := new(big.Int).Exp(f.verifier(), u, srpN)
+	S.Mul(S, f.A)
+	S.Exp(S, f.b, srpN)
+	K := srpHash(S.Bytes())
+
+	hN := srpHash(srpN.Bytes())
+	hG := srpHash(srpPad(srpG))
+	for i := range hN {
+		hN[i] ^= hG[i]
+	}
+	expected := srpHash(hN, srpHash([]byte(f.username)), f.salt, f.A.Bytes(), f.B.Bytes(), K)
+	if string(expected) != string(m1) {
+		return map[string]any{"Status": map[string]any{"ec": -20101, "em": "wrong password"}}
+	}
+
+	spd, _ := plist.Marshal(map[string]any{
+		"adsid":       "adsid",
+		"GsIdmsToken": "idms",
+		"t": map[string]any{
+			"com.apple.gs.idms.pet": map[string]any{"token": "pet"},
+		},
+	}, plist.XMLFormat)
+	status := map[string]any{"ec": 0}
+	if f.authType != "" {
+		status["au"] = f.authType
+	}
+	return map[string]any{
+		"Status": status,
+		"M2":     srpHash(f.A.Bytes(), m1, K),
+		"spd":    encryptSPD(f.t, K, spd),
+	}
+}
+
+func encryptSPD(t *testing.T, k []byte, data []byte) []byte {
+	t.Helper()
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	for range padding {
+		data = append(data, byte(padding))
+	}
+	block, err := aes.NewCipher(sessionKey(k, "extra data key:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, sessionKey(k, "extra data iv:")[:aes.BlockSize]).CryptBlocks(out, data)
+	return out
+}
+
+func TestAuthenticate(t *testing.T) {
+	f := &fakeGSA{t: t, username: "user@example.com", password: "password", salt: []byte("salt")}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := New(fakeAnisette{}, WithBaseURL(srv.URL))
+
+	session, _, err := c.Authenticate(context.Background(), f.username, f.password)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if session.ADSID != "adsid" || session.PET() != "pet" {
+		t.Errorf("unexpected session: %+v", session)
+	}
+
+	if _, _, err := c.Authenticate(context.Background(), f.username, "wrong"); err == nil {
+		t.Error("expected an error with a wrong password")
+	}
+
+	f.authType = authTypeTrustedDevice
+	_, authType, err := c.Authenticate(context.Background(), f.username, f.password)
+	if err != ErrSecondFactorRequired || authType != authTypeTrustedDevice {
+		t.Errorf("expected a second factor to be required, got %v (%s)", err, authType)
+	}
+}
+
+func TestParseDelegates(t *testing.T) {
+	b, _ := plist.Marshal(map[string]any{
+		"dsid": 1234,
+		"delegates": map[string]any{
+			"com.apple.mobileme": map[string]any{
+				"status": 0,
+				"service-data": map[string]any{
+					"tokens": map[string]string{"searchPartyToken": "spt", "mmeAuthToken": "mme"},
+				},
+			},
+		},
+	}, plist.XMLFormat)
+	d, err := parseDelegates(b)
+	if err != nil {
+		t.Fatalf("parseDelegates failed: %v", err)
+	}
+	if d.DSID != "1234" || d.SearchPartyToken != "spt" || d.MmeAuthToken != "mme" {
+		t.Errorf("unexpected delegates: %+v", d)
+	}
+}
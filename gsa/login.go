@@ -0,0 +1,177 @@
+package gsa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"howett.net/plist"
+
+	"github.com/denysvitali/searchparty-go"
+)
+
+const delegatesPath = "/setup/iosbuddy/loginDelegates"
+
+// Delegates are the iCloud tokens returned by the loginDelegates endpoint
+type Delegates struct {
+	DSID             string
+	SearchPartyToken string
+	MmeAuthToken     string
+}
+
+// Login authenticates username, going through the second factor if needed,
+// and returns the search party credentials. If preferSMS is set the 2FA
+// code is sent via SMS even when trusted devices are available.
+func (c *Client) Login(ctx context.Context, username string, password string, prompt CodePrompt, preferSMS bool) (*searchparty.Auth, error) {
+	session, authType, err := c.Authenticate(ctx, username, password)
+	if errors.Is(err, ErrSecondFactorRequired) {
+		logger.Debugf("second factor required (%s)", authType)
+		switch {
+		case authType == authTypeTrustedDevice && !preferSMS:
+			err = c.TrustedDeviceSecondFactor(ctx, session, prompt)
+		default:
+			err = c.SMSSecondFactor(ctx, session, prompt)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("second factor: %w", err)
+		}
+		// The session is only complete after authenticating again
+		session, _, err = c.Authenticate(ctx, username, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.PET() == "" {
+		return nil, errors.New("no password equivalent token in session")
+	}
+
+	delegates, err := c.LoginDelegates(ctx, username, session)
+	if err != nil {
+		return nil, err
+	}
+	return &searchparty.Auth{
+		Dsid:             delegates.DSID,
+		SearchPartyToken: delegates.SearchPartyToken,
+		AppleID:          username,
+		ADSID:            session.ADSID,
+		PET:              session.PET(),
+		MmeAuthToken:     delegates.MmeAuthToken,
+	}, nil
+}
+
+// Refresh renews the search party token using the password equivalent token
+// stored in auth. It implements searchparty.TokenRefresher.
+func (c *Client) Refresh(ctx context.Context, auth *searchparty.Auth) (*searchparty.Auth, error) {
+	if auth.AppleID == "" || auth.PET == "" {
+		return nil, errors.New("auth has no long lived credentials, run searchparty login")
+	}
+	session := &Session{
+		ADSID:  auth.ADSID,
+		Tokens: map[string]Token{petToken: {Token: auth.PET}},
+	}
+	delegates, err := c.LoginDelegates(ctx, auth.AppleID, session)
+	if err != nil {
+		return nil, fmt.Errorf("unable to renew token (the PET might have expired, run searchparty login): %w", err)
+	}
+	renewed := *auth
+	renewed.Dsid = delegates.DSID
+	renewed.SearchPartyToken = delegates.SearchPartyToken
+	if delegates.MmeAuthToken != "" {
+		renewed.MmeAuthToken = delegates.MmeAuthToken
+	}
+	return &renewed, nil
+}
+
+var _ searchparty.TokenRefresher = (*Client)(nil)
+
+// LoginDelegates exchanges the password equivalent token of session for the
+// iCloud (com.apple.mobileme) tokens, including the search party token
+func (c *Client) LoginDelegates(ctx context.Context, username string, session *Session) (*Delegates, error) {
+	body, err := plist.Marshal(map[string]any{
+		"apple-id":  username,
+		"delegates": map[string]any{"com.apple.mobileme": map[string]any{}},
+		"password":  session.PET(),
+		"client-id": uuid.NewString(),
+	}, plist.XMLFormat)
+	if err != nil {
+		return nil, err
+	}
+	h, err := c.anisette.Headers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get anisette headers: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.setupURL+delegatesPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = h
+	req.Header.Set("X-Apple-ADSID", session.ADSID)
+	req.Header.Set("User-Agent", "com.apple.iCloudHelper/282 CFNetwork/1408.0.4 Darwin/22.5.0")
+	req.Header.Set("X-Mme-Client-Info", "<MacBookPro18,3> <Mac OS X;13.4.1;22F8> <com.apple.AOSKit/282 (com.apple.accountsd/113)>")
+	req.SetBasicAuth(username, session.PET())
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get delegates: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to get delegates: unexpected status code: %d", res.StatusCode)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseDelegates(b)
+}
+
+func parseDelegates(b []byte) (*Delegates, error) {
+	var response struct {
+		DSID      any `plist:"dsid"`
+		Delegates map[string]struct {
+			Status        int    `plist:"status"`
+			StatusMessage string `plist:"status-message"`
+			ServiceData   struct {
+				DSID   any               `plist:"dsid"`
+				Tokens map[string]string `plist:"tokens"`
+			} `plist:"service-data"`
+		} `plist:"delegates"`
+	}
+	if _, err := plist.Unmarshal(b, &response); err != nil {
+		return nil, fmt.Errorf("unable to decode delegates: %w", err)
+	}
+	mobileMe, ok := response.Delegates["com.apple.mobileme"]
+	if !ok {
+		return nil, errors.New("com.apple.mobileme delegate not found")
+	}
+	if mobileMe.Status != 0 {
+		return nil, fmt.Errorf("com.apple.mobileme delegate error %d: %s", mobileMe.Status, mobileMe.StatusMessage)
+	}
+	d := Delegates{
+		DSID:             toString(response.DSID),
+		SearchPartyToken: mobileMe.ServiceData.Tokens["searchPartyToken"],
+		MmeAuthToken:     mobileMe.ServiceData.Tokens["mmeAuthToken"],
+	}
+	if d.DSID == "" {
+		d.DSID = toString(mobileMe.ServiceData.DSID)
+	}
+	if d.DSID == "" || d.SearchPartyToken == "" {
+		return nil, errors.New("dsid or searchPartyToken missing from delegates")
+	}
+	return &d, nil
+}
+
+// toString formats a plist value that can either be a string or an integer
+func toString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
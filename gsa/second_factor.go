@@ -0,0 +1,143 @@
+package gsa
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"howett.net/plist"
+)
+
+const (
+	authTypeTrustedDevice = "trustedDeviceSecondaryAuth"
+	authTypeSMS           = "secondaryAuth"
+)
+
+// SecondFactorMethod is the way the 2FA code is delivered
+type SecondFactorMethod int
+
+const (
+	TrustedDevice SecondFactorMethod = iota
+	SMS
+)
+
+func (m SecondFactorMethod) String() string {
+	switch m {
+	case TrustedDevice:
+		return "trusted device"
+	case SMS:
+		return "SMS"
+	default:
+		return "unknown"
+	}
+}
+
+// CodePrompt asks the user for the 2FA code delivered via method
+type CodePrompt func(method SecondFactorMethod) (string, error)
+
+// secondFactorHeaders returns the headers of the 2FA requests
+func (c *Client) secondFactorHeaders(ctx context.Context, session *Session, contentType string) (http.Header, error) {
+	h, err := c.anisette.Headers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get anisette headers: %w", err)
+	}
+	identityToken := base64.StdEncoding.EncodeToString([]byte(session.ADSID + ":" + session.IdmsToken))
+	h.Set("Content-Type", contentType)
+	h.Set("Accept", contentType)
+	h.Set("Accept-Language", "en-us")
+	h.Set("User-Agent", "Xcode")
+	h.Set("X-Apple-Identity-Token", identityToken)
+	h.Set("X-Apple-App-Info", "com.apple.gs.xcode.auth")
+	h.Set("X-Xcode-Version", "11.2 (11B41)")
+	h.Set("X-Mme-Client-Info", clientInfo)
+	return h, nil
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, h http.Header, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = h
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return b, nil
+}
+
+// TrustedDeviceSecondFactor sends a 2FA code to the trusted devices of the
+// account and validates the code returned by prompt
+func (c *Client) TrustedDeviceSecondFactor(ctx context.Context, session *Session, prompt CodePrompt) error {
+	h, err := c.secondFactorHeaders(ctx, session, "text/x-xml-plist")
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(ctx, http.MethodGet, "/auth/verify/trusteddevice", h, nil); err != nil {
+		return fmt.Errorf("unable to request code: %w", err)
+	}
+	code, err := prompt(TrustedDevice)
+	if err != nil {
+		return err
+	}
+	h.Set("security-code", code)
+	b, err := c.do(ctx, http.MethodGet, gsaPath+"/validate", h, nil)
+	if err != nil {
+		return fmt.Errorf("unable to validate code: %w", err)
+	}
+	var res struct {
+		ErrorCode    int    `plist:"ec"`
+		ErrorMessage string `plist:"em"`
+	}
+	if _, err := plist.Unmarshal(b, &res); err == nil && res.ErrorCode != 0 {
+		return fmt.Errorf("unable to validate code: gsa error %d: %s", res.ErrorCode, res.ErrorMessage)
+	}
+	return nil
+}
+
+// SMSSecondFactor sends a 2FA code via SMS to the first trusted phone number
+// of the account and validates the code returned by prompt
+func (c *Client) SMSSecondFactor(ctx context.Context, session *Session, prompt CodePrompt) error {
+	h, err := c.secondFactorHeaders(ctx, session, "application/json")
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{
+		"phoneNumber": map[string]int{"id": 1},
+		"mode":        "sms",
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(ctx, http.MethodPut, "/auth/verify/phone/", h, body); err != nil {
+		return fmt.Errorf("unable to request code: %w", err)
+	}
+	code, err := prompt(SMS)
+	if err != nil {
+		return err
+	}
+	body, err = json.Marshal(map[string]any{
+		"phoneNumber":  map[string]int{"id": 1},
+		"securityCode": map[string]string{"code": code},
+		"mode":         "sms",
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(ctx, http.MethodPost, "/auth/verify/phone/securitycode", h, body); err != nil {
+		return fmt.Errorf("unable to validate code: %w", err)
+	}
+	return nil
+}
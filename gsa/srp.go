@@ -0,0 +1,108 @@
+package gsa
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+)
+
+// srpN is the 2048-bit group from RFC 5054
+var srpN, _ = new(big.Int).SetString(
+	"AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B855F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773BCA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB694B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73",
+	16,
+)
+
+var srpG = big.NewInt(2)
+
+// srpClient is the client side of SRP-6a as used by GSA: SHA-256, the RFC 5054
+// 2048-bit group with RFC 5054 padding, and no username in x
+type srpClient struct {
+	username []byte
+	a        *big.Int
+	A        *big.Int
+	K        []byte
+	M1       []byte
+}
+
+func newSRPClient(username string) (*srpClient, error) {
+	aBytes := make([]byte, 32)
+	if _, err := rand.Read(aBytes); err != nil {
+		return nil, err
+	}
+	a := new(big.Int).SetBytes(aBytes)
+	return &srpClient{
+		username: []byte(username),
+		a:        a,
+		A:        new(big.Int).Exp(srpG, a, srpN),
+	}, nil
+}
+
+// publicKey returns A, sent to the server in the init request
+func (c *srpClient) publicKey() []byte {
+	return c.A.Bytes()
+}
+
+// processChallenge computes the session key and M1 from the salt and B sent
+// by the server. password is the key derived with derivePassword.
+func (c *srpClient) processChallenge(password []byte, salt []byte, bBytes []byte) ([]byte, error) {
+	B := new(big.Int).SetBytes(bBytes)
+	if new(big.Int).Mod(B, srpN).Sign() == 0 {
+		return nil, errors.New("invalid server public key")
+	}
+	u := new(big.Int).SetBytes(srpHash(srpPad(c.A), srpPad(B)))
+	if u.Sign() == 0 {
+		return nil, errors.New("invalid server public key")
+	}
+	s := new(big.Int).SetBytes(salt).Bytes()
+	x := new(big.Int).SetBytes(srpHash(s, srpHash([]byte(":"), password)))
+	k := new(big.Int).SetBytes(srpHash(srpN.Bytes(), srpPad(srpG)))
+
+	// S = (B - k * g^x) ^ (a + u * x) mod N
+	base := new(big.Int).Exp(srpG, x, srpN)
+	base.Mul(base, k)
+	base.Sub(B, base)
+	base.Mod(base, srpN)
+	exp := new(big.Int).Mul(u, x)
+	exp.Add(exp, c.a)
+	S := new(big.Int).Exp(base, exp, srpN)
+
+	c.K = srpHash(S.Bytes())
+
+	hN := srpHash(srpN.Bytes())
+	hG := srpHash(srpPad(srpG))
+	for i := range hN {
+		hN[i] ^= hG[i]
+	}
+	c.M1 = srpHash(hN, srpHash(c.username), s, c.A.Bytes(), B.Bytes(), c.K)
+	return c.M1, nil
+}
+
+// verifySession checks the M2 proof sent by the server
+func (c *srpClient) verifySession(m2 []byte) error {
+	expected := srpHash(c.A.Bytes(), c.M1, c.K)
+	if subtle.ConstantTimeCompare(expected, m2) != 1 {
+		return errors.New("invalid server proof")
+	}
+	return nil
+}
+
+func srpHash(data ...[]byte) []byte {
+	h := sha256.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// srpPad left pads n to the length of N
+func srpPad(n *big.Int) []byte {
+	b := n.Bytes()
+	size := len(srpN.Bytes())
+	if len(b) >= size {
+		return b
+	}
+	return append(bytes.Repeat([]byte{0}, size-len(b)), b...)
+}
@@ -46,3 +46,16 @@ func LoadKeys(dir string, key []byte) ([]model.MainKey, error) {
 	}
 	return keys, nil
 }
+
+// LoadKeyMap loads the keys in dir, indexed by key ID
+func LoadKeyMap(dir string, key []byte) (map[string]model.MainKey, error) {
+	keys, err := LoadKeys(dir, key)
+	if err != nil {
+		return nil, err
+	}
+	keyMap := make(map[string]model.MainKey, len(keys))
+	for _, k := range keys {
+		keyMap[k.ID()] = k
+	}
+	return keyMap, nil
+}
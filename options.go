@@ -0,0 +1,106 @@
+package searchparty
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithBaseURL sets the base URL of the Apple endpoint (default: https://gateway.icloud.com).
+// Useful to point the client at a local stand-in server.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithAnisetteProvider sets the provider of the anisette headers, replacing
+// the default one that uses the anisette URL passed to New
+func WithAnisetteProvider(provider AnisetteProvider) Option {
+	return func(c *Client) {
+		c.anisette = provider
+	}
+}
+
+// WithHTTPClient sets the *http.Client used for both the anisette and the Apple requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets a timeout for every call to Find, including the anisette request
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithUserAgent sets the User-Agent header of outgoing requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithBatchSize sets the maximum number of IDs sent in a single fetch request
+func WithBatchSize(batchSize int) Option {
+	return func(c *Client) {
+		c.batchSize = batchSize
+	}
+}
+
+// WithMaxWindow sets the longest time range covered by a single fetch request.
+// Longer ranges are split into multiple requests.
+func WithMaxWindow(maxWindow time.Duration) Option {
+	return func(c *Client) {
+		c.maxWindow = maxWindow
+	}
+}
+
+// WithConcurrency sets the maximum number of fetch requests in flight for a single Find call
+func WithConcurrency(concurrency int) Option {
+	return func(c *Client) {
+		if concurrency > 0 {
+			c.concurrency = concurrency
+		}
+	}
+}
+
+// WithRateLimit sets the token bucket used to limit the requests sent to Apple.
+// The limiter is shared by every call made through the Client.
+func WithRateLimit(limit rate.Limit, burst int) Option {
+	return func(c *Client) {
+		c.limiter = rate.NewLimiter(limit, burst)
+	}
+}
+
+// WithRetry configures the retries on 429 and 5xx responses: up to maxRetries
+// retries with an exponential backoff starting at baseDelay and capped at maxDelay
+func WithRetry(maxRetries int, baseDelay time.Duration, maxDelay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.baseDelay = baseDelay
+		c.maxDelay = maxDelay
+	}
+}
+
+// WithTokenRefresher sets the TokenRefresher used to renew the search party
+// token when Apple rejects it. The request is then retried once.
+func WithTokenRefresher(refresher TokenRefresher) Option {
+	return func(c *Client) {
+		c.creds.refresher = refresher
+	}
+}
+
+// WithAuthFile sets the file the renewed Auth is written to
+func WithAuthFile(authFile string) Option {
+	return func(c *Client) {
+		c.creds.authFile = authFile
+	}
+}
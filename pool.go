@@ -0,0 +1,220 @@
+package searchparty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/model"
+)
+
+const (
+	// defaultRateLimitCooldown is how long an account is taken out of rotation after being rate limited
+	defaultRateLimitCooldown = 15 * time.Minute
+	// defaultUnauthorizedCooldown is how long an account is taken out of rotation after its credentials were rejected
+	defaultUnauthorizedCooldown = 1 * time.Hour
+)
+
+var (
+	// ErrNoAccountAvailable is returned when all the accounts of a pool are
+	// out of rotation, at least one of them for being rate limited
+	ErrNoAccountAvailable = fmt.Errorf("%w: no account available", ErrRateLimited)
+	// ErrNoAuthorizedAccount is returned when all the accounts of a pool are
+	// out of rotation because their credentials were rejected
+	ErrNoAuthorizedAccount = fmt.Errorf("%w: no authorized account", ErrUnauthorized)
+)
+
+// Finder finds the reports of a set of keys. It's implemented by Client and AccountPool.
+type Finder interface {
+	FindRange(ctx context.Context, keys []model.MainKey, opts FindOptions) ([]Report, map[string]model.SubKey, error)
+}
+
+var (
+	_ Finder = Client{}
+	_ Finder = (*AccountPool)(nil)
+)
+
+// ClientFactory creates the Client of the account named name, whose Auth is stored in authFile
+type ClientFactory func(name string, auth *Auth, authFile string) (*Client, error)
+
+// AccountStatus describes an account of an AccountPool
+type AccountStatus struct {
+	Name          string    `json:"name"`
+	Available     bool      `json:"available"`
+	DisabledUntil time.Time `json:"disabledUntil,omitempty"`
+	LastError     string    `json:"lastError,omitempty"`
+}
+
+type poolAccount struct {
+	name          string
+	client        *Client
+	disabledUntil time.Time
+	lastError     error
+}
+
+// AccountPool distributes the requests across multiple Apple IDs, taking an
+// account out of rotation when it gets rate limited or its credentials are rejected.
+// Each account has its own Client, and thus its own rate limiter and anisette identity.
+type AccountPool struct {
+	RateLimitCooldown    time.Duration
+	UnauthorizedCooldown time.Duration
+
+	mu       sync.Mutex
+	accounts []*poolAccount
+	next     int
+}
+
+// NewAccountPool returns a pool with the given clients, indexed by account name
+func NewAccountPool(clients map[string]*Client) *AccountPool {
+	names := make([]string, 0, len(clients))
+	for name := range clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	p := AccountPool{
+		RateLimitCooldown:    defaultRateLimitCooldown,
+		UnauthorizedCooldown: defaultUnauthorizedCooldown,
+	}
+	for _, name := range names {
+		p.accounts = append(p.accounts, &poolAccount{name: name, client: clients[name]})
+	}
+	return &p
+}
+
+// LoadAccountPool creates a pool with an account for each auth file (*.json) in dir
+func LoadAccountPool(dir string, newClient ClientFactory) (*AccountPool, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	clients := make(map[string]*Client)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), ".json")
+		authFile := filepath.Join(dir, f.Name())
+		auth, err := GetAuth(authFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load auth file %s: %w", f.Name(), err)
+		}
+		c, err := newClient(name, auth, authFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create client for %s: %w", name, err)
+		}
+		clients[name] = c
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("no auth files found in %s", dir)
+	}
+	return NewAccountPool(clients), nil
+}
+
+// FindRange runs the request with the next available account, moving on to
+// the following one if the account is rate limited or unauthorized
+func (p *AccountPool) FindRange(ctx context.Context, keys []model.MainKey, opts FindOptions) ([]Report, map[string]model.SubKey, error) {
+	tried := make(map[*poolAccount]struct{})
+	for {
+		a := p.acquire(tried)
+		if a == nil {
+			return nil, nil, p.unavailableError()
+		}
+		tried[a] = struct{}{}
+		reports, subKeys, err := a.client.FindRange(ctx, keys, opts)
+		switch {
+		case errors.Is(err, ErrRateLimited):
+			p.disable(a, p.RateLimitCooldown, err)
+		case errors.Is(err, ErrUnauthorized):
+			p.disable(a, p.UnauthorizedCooldown, err)
+		case err == nil:
+			p.succeed(a)
+			return reports, subKeys, nil
+		default:
+			return reports, subKeys, err
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// Find returns the reports published in the last hours
+func (p *AccountPool) Find(ctx context.Context, keys []model.MainKey, hours int, lostAt time.Time) ([]Report, map[string]model.SubKey, error) {
+	return p.FindRange(ctx, keys, FindOptions{
+		From:   time.Now().Add(-time.Duration(hours) * time.Hour),
+		LostAt: lostAt,
+	})
+}
+
+// Accounts returns the status of the accounts in the pool
+func (p *AccountPool) Accounts() []AccountStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	res := make([]AccountStatus, 0, len(p.accounts))
+	for _, a := range p.accounts {
+		s := AccountStatus{
+			Name:      a.name,
+			Available: !now.Before(a.disabledUntil),
+		}
+		if !s.Available {
+			s.DisabledUntil = a.disabledUntil
+		}
+		if a.lastError != nil {
+			s.LastError = a.lastError.Error()
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// acquire returns the next available account (round robin) not in tried
+func (p *AccountPool) acquire(tried map[*poolAccount]struct{}) *poolAccount {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for i := range p.accounts {
+		a := p.accounts[(p.next+i)%len(p.accounts)]
+		if _, ok := tried[a]; ok || now.Before(a.disabledUntil) {
+			continue
+		}
+		p.next = (p.next + i + 1) % len(p.accounts)
+		return a
+	}
+	return nil
+}
+
+// unavailableError returns the error for a pool without available accounts:
+// ErrNoAccountAvailable if any account is rate limited, ErrNoAuthorizedAccount
+// otherwise
+func (p *AccountPool) unavailableError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, a := range p.accounts {
+		if errors.Is(a.lastError, ErrRateLimited) {
+			return ErrNoAccountAvailable
+		}
+	}
+	return ErrNoAuthorizedAccount
+}
+
+// succeed clears the last error of a, it works again
+func (p *AccountPool) succeed(a *poolAccount) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a.lastError = nil
+}
+
+func (p *AccountPool) disable(a *poolAccount, cooldown time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	logger.Warnf("taking account %s out of rotation for %s: %v", a.name, cooldown, err)
+	a.disabledUntil = time.Now().Add(cooldown)
+	a.lastError = err
+}
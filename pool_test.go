@@ -0,0 +1,119 @@
+package searchparty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/denysvitali/searchparty-go/model"
+)
+
+func newPoolTestClient(t *testing.T, statusCode int, calls *atomic.Int32) *Client {
+	t.Helper()
+	var status atomic.Int32
+	status.Store(int32(statusCode)) //nolint:gosec
+	return newPoolTestClientStatus(t, &status, calls)
+}
+
+// newPoolTestClientStatus returns a client of a server answering with status
+func newPoolTestClientStatus(t *testing.T, status *atomic.Int32, calls *atomic.Int32) *Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/anisette", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(AnisetteResponse{})
+	})
+	mux.HandleFunc(fetchReportsPath, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if statusCode := int(status.Load()); statusCode != http.StatusOK {
+			w.WriteHeader(statusCode)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(FindResult{})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return New(&Auth{}, srv.URL+"/anisette",
+		WithBaseURL(srv.URL),
+		WithRateLimit(rate.Inf, 1),
+		WithRetry(0, time.Millisecond, time.Millisecond),
+	)
+}
+
+func TestAccountPool(t *testing.T) {
+	var limitedCalls, okCalls atomic.Int32
+	p := NewAccountPool(map[string]*Client{
+		"a-limited": newPoolTestClient(t, http.StatusTooManyRequests, &limitedCalls),
+		"b-ok":      newPoolTestClient(t, http.StatusOK, &okCalls),
+	})
+	key := &StaticKey{keyID: "test", hashedAdvKey: []byte("key")}
+	for range 3 {
+		if _, _, err := p.Find(context.Background(), []model.MainKey{key}, 1, time.Time{}); err != nil {
+			t.Fatalf("Find failed: %v", err)
+		}
+	}
+	if limitedCalls.Load() != 1 {
+		t.Errorf("expected the rate limited account to be used once, got %d", limitedCalls.Load())
+	}
+	if okCalls.Load() != 3 {
+		t.Errorf("expected 3 calls to the available account, got %d", okCalls.Load())
+	}
+	for _, a := range p.Accounts() {
+		if a.Available != (a.Name == "b-ok") {
+			t.Errorf("unexpected status for %s: %+v", a.Name, a)
+		}
+	}
+}
+
+func TestAccountPoolNoAccountAvailable(t *testing.T) {
+	var calls atomic.Int32
+	p := NewAccountPool(map[string]*Client{
+		"unauthorized": newPoolTestClient(t, http.StatusUnauthorized, &calls),
+	})
+	key := &StaticKey{keyID: "test", hashedAdvKey: []byte("key")}
+	_, _, err := p.Find(context.Background(), []model.MainKey{key}, 1, time.Time{})
+	if !errors.Is(err, ErrNoAuthorizedAccount) || !errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrNoAuthorizedAccount, got %v", err)
+	}
+
+	var limitedCalls atomic.Int32
+	p = NewAccountPool(map[string]*Client{
+		"limited":      newPoolTestClient(t, http.StatusTooManyRequests, &limitedCalls),
+		"unauthorized": newPoolTestClient(t, http.StatusUnauthorized, &calls),
+	})
+	_, _, err = p.Find(context.Background(), []model.MainKey{key}, 1, time.Time{})
+	if !errors.Is(err, ErrNoAccountAvailable) || !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrNoAccountAvailable, got %v", err)
+	}
+}
+
+func TestAccountPoolRecovers(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusTooManyRequests)
+	p := NewAccountPool(map[string]*Client{
+		"a": newPoolTestClientStatus(t, &status, &calls),
+	})
+	p.RateLimitCooldown = 0
+	key := &StaticKey{keyID: "test", hashedAdvKey: []byte("key")}
+	if _, _, err := p.Find(context.Background(), []model.MainKey{key}, 1, time.Time{}); !errors.Is(err, ErrNoAccountAvailable) {
+		t.Fatalf("expected ErrNoAccountAvailable, got %v", err)
+	}
+	if a := p.Accounts()[0]; a.LastError == "" {
+		t.Fatalf("expected the rate limit to be reported, got %+v", a)
+	}
+
+	// A successful request clears the error
+	status.Store(http.StatusOK)
+	if _, _, err := p.Find(context.Background(), []model.MainKey{key}, 1, time.Time{}); err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if a := p.Accounts()[0]; a.LastError != "" || !a.Available {
+		t.Fatalf("expected a healthy account, got %+v", a)
+	}
+}
@@ -39,7 +39,23 @@ type TagData struct {
 	Lat        float64   `json:"lat"`
 	Lng        float64   `json:"lng"`
 	Confidence int       `json:"confidence"`
-	Status     int       `json:"status"`
+	// Status is the raw status byte, Battery and Maintained are decoded from it
+	Status     Status       `json:"status"`
+	Battery    BatteryLevel `json:"battery"`
+	Maintained bool         `json:"maintained"`
+}
+
+// NewTagData returns the TagData of a location with the status decoded
+func NewTagData(foundAt time.Time, lat, lng float64, confidence int, status Status) TagData {
+	return TagData{
+		Time:       foundAt,
+		Lat:        lat,
+		Lng:        lng,
+		Confidence: confidence,
+		Status:     status,
+		Battery:    status.Battery(),
+		Maintained: status.Maintained(),
+	}
 }
 
 func (t TagData) String() string {
@@ -58,13 +74,9 @@ func decodeTag(data []byte) (*TagData, error) {
 	latitude := float64(int32(binary.BigEndian.Uint32(data[:4]))) / 10000000.0
 	longitude := float64(int32(binary.BigEndian.Uint32(data[4:8]))) / 10000000.0
 	confidence := int(data[8])
-	status := int(data[9])
-	return &TagData{
-		Lat:        latitude,
-		Lng:        longitude,
-		Confidence: confidence,
-		Status:     status,
-	}, nil
+	status := Status(data[9])
+	tagData := NewTagData(time.Time{}, latitude, longitude, confidence, status)
+	return &tagData, nil
 }
 
 func sha256Hash(data []byte) []byte {
@@ -0,0 +1,77 @@
+package searchparty
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrUnauthorized is returned when Apple rejects the credentials in Auth
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited is returned when Apple keeps rate limiting the requests after all the retries
+	ErrRateLimited = errors.New("rate limited")
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBaseDelay  = 1 * time.Second
+	defaultMaxDelay   = 1 * time.Minute
+)
+
+// shouldRetry returns true for the status codes that are worth retrying
+func shouldRetry(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// backoff returns how long to wait before the given (zero based) retry attempt.
+// The Retry-After header of res is honored when present, otherwise an
+// exponential backoff with full jitter is used.
+func (c Client) backoff(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if d, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+			return min(d, c.maxDelay)
+		}
+	}
+	d := c.baseDelay << attempt
+	if d <= 0 || d > c.maxDelay {
+		d = c.maxDelay
+	}
+	if d <= 0 {
+		// Retries without delay, rand.N panics on 0
+		return 0
+	}
+	return rand.N(d) + 1 //nolint:gosec
+}
+
+// parseRetryAfter parses a Retry-After header, either in seconds or as an HTTP date
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+// Package battery raises an alert when the battery of a key becomes low
+package battery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "battery")
+
+// LowHandler is called when the battery of the key of location drops to
+// low or critical. previous is the level of the previous location, nil for
+// the first location of the key.
+type LowHandler func(ctx context.Context, location *models.Location, previous *searchparty.BatteryLevel)
+
+// Monitor compares the battery level of the new locations with the one of
+// the previous location of their key. It implements store.LocationHook.
+type Monitor struct {
+	st store.Store
+
+	mu       sync.Mutex
+	handlers []LowHandler
+}
+
+// New returns a Monitor reading the previous locations from st
+func New(st store.Store) *Monitor {
+	return &Monitor{st: st}
+}
+
+// OnLow registers h to be called for every low battery alert
+func (m *Monitor) OnLow(h LowHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, h)
+}
+
+// LocationSaved checks location, errors are only logged
+func (m *Monitor) LocationSaved(ctx context.Context, location *models.Location) {
+	if err := m.Check(ctx, location); err != nil {
+		logger.Errorf("unable to check the battery of %s: %v", location.KeyID, err)
+	}
+}
+
+// Check alerts the handlers if location reports a low or critical battery
+// while the previous location of its key reported a higher level. Locations
+// older than the latest stored one are ignored.
+func (m *Monitor) Check(ctx context.Context, location *models.Location) error {
+	level := location.DecodedStatus().Battery()
+	if !level.IsLow() {
+		return nil
+	}
+	latest, err := m.st.LastLocation(ctx, location.KeyID, time.Time{})
+	if err != nil {
+		return err
+	}
+	if latest.FoundAt.After(location.FoundAt) {
+		return nil
+	}
+	var previous *searchparty.BatteryLevel
+	prev, err := m.st.LastLocation(ctx, location.KeyID, location.FoundAt)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		p := prev.DecodedStatus().Battery()
+		if p >= level {
+			return nil
+		}
+		previous = &p
+	}
+
+	m.mu.Lock()
+	handlers := append([]LowHandler(nil), m.handlers...)
+	m.mu.Unlock()
+	for _, h := range handlers {
+		h(ctx, location, previous)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package battery
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func newLocation(t *testing.T, foundAt time.Time, battery searchparty.BatteryLevel) *models.Location {
+	t.Helper()
+	p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{8.54, 47.37})
+	if err != nil {
+		t.Fatalf("unable to create point: %v", err)
+	}
+	g := models.GeomPoint(*p)
+	return &models.Location{FoundAt: foundAt, KeyID: "a/b", Geometry: &g, Status: int(battery) << 6}
+}
+
+func TestMonitor(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	m := New(st)
+	type alert struct {
+		battery  searchparty.BatteryLevel
+		previous *searchparty.BatteryLevel
+	}
+	var alerts []alert
+	m.OnLow(func(_ context.Context, l *models.Location, previous *searchparty.BatteryLevel) {
+		alerts = append(alerts, alert{l.DecodedStatus().Battery(), previous})
+	})
+	hooked := store.WithHooks(st, m)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	levels := []searchparty.BatteryLevel{
+		searchparty.BatteryLow,      // first location: alert
+		searchparty.BatteryFull,     // not low
+		searchparty.BatteryMedium,   // not low
+		searchparty.BatteryLow,      // dropped from medium: alert
+		searchparty.BatteryLow,      // unchanged
+		searchparty.BatteryCritical, // dropped from low: alert
+	}
+	for i, level := range levels {
+		if _, err := hooked.SaveLocation(ctx, newLocation(t, start.Add(time.Duration(i)*time.Hour), level)); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+	}
+	// An older location arriving late doesn't alert
+	if _, err := hooked.SaveLocation(ctx, newLocation(t, start.Add(150*time.Minute), searchparty.BatteryCritical)); err != nil {
+		t.Fatalf("unable to save location: %v", err)
+	}
+
+	if len(alerts) != 3 {
+		t.Fatalf("expected 3 alerts, got %d", len(alerts))
+	}
+	if alerts[0].battery != searchparty.BatteryLow || alerts[0].previous != nil {
+		t.Errorf("unexpected first alert %+v", alerts[0])
+	}
+	if alerts[1].battery != searchparty.BatteryLow || alerts[1].previous == nil || *alerts[1].previous != searchparty.BatteryMedium {
+		t.Errorf("unexpected second alert %+v", alerts[1])
+	}
+	if alerts[2].battery != searchparty.BatteryCritical || alerts[2].previous == nil || *alerts[2].previous != searchparty.BatteryLow {
+		t.Errorf("unexpected third alert %+v", alerts[2])
+	}
+}
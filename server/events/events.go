@@ -0,0 +1,142 @@
+// Package events broadcasts the new locations and the key status changes to
+// the live streams, which can resume from a previous location
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+// Event types
+const (
+	// TypeLocation is a new location of a key
+	TypeLocation = "location"
+	// TypeKey is a change of the status of a key, e.g. marked as lost
+	TypeKey = "key"
+)
+
+// subscriptionBuffer is the number of events a subscriber can lag behind
+// before being disconnected
+const subscriptionBuffer = 256
+
+// Event is a new location or a key status change
+type Event struct {
+	Type  string `json:"type"`
+	KeyID string `json:"keyId"`
+	// Time is when the location was found, or when the key changed
+	Time     time.Time              `json:"time"`
+	Location *models.LocationResult `json:"location,omitempty"`
+	Key      *models.KeyInfo        `json:"key,omitempty"`
+	// Cursor is when the location was stored, the streams resume after it
+	Cursor time.Time `json:"-"`
+}
+
+// NewLocationEvent returns the event of a new location
+func NewLocationEvent(l *models.Location) Event {
+	result := models.NewLocationResult(l)
+	return Event{
+		Type:     TypeLocation,
+		KeyID:    l.KeyID,
+		Time:     l.FoundAt,
+		Location: &result,
+		Cursor:   l.CreatedAt,
+	}
+}
+
+// Broker broadcasts the events to the subscriptions. It implements
+// store.LocationHook.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBroker returns a Broker without subscriptions
+func NewBroker() *Broker {
+	return &Broker{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the events of some keys
+type Subscription struct {
+	// C receives the events, it's closed when the subscription is closed
+	C <-chan Event
+
+	c      chan Event
+	keyIDs map[string]bool
+	b      *Broker
+	// overflowed is set when the subscription was closed for lagging behind
+	overflowed bool
+}
+
+// Subscribe returns a subscription to the events of keyIDs, all the keys
+// if empty. It must be closed.
+func (b *Broker) Subscribe(keyIDs []string) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	s := &Subscription{C: c, c: c, b: b}
+	if len(keyIDs) > 0 {
+		s.keyIDs = map[string]bool{}
+		for _, id := range keyIDs {
+			s.keyIDs[id] = true
+		}
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.close()
+}
+
+// close must be called with the broker's lock held
+func (s *Subscription) close() {
+	if _, ok := s.b.subs[s]; ok {
+		delete(s.b.subs, s)
+		close(s.c)
+	}
+}
+
+// Overflowed returns whether the subscription was closed because its
+// events weren't received fast enough
+func (s *Subscription) Overflowed() bool {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	return s.overflowed
+}
+
+// Publish sends e to the subscriptions to its key. The subscriptions that
+// can't keep up are closed rather than slowing down the publisher.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if s.keyIDs != nil && !s.keyIDs[e.KeyID] {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.overflowed = true
+			s.close()
+		}
+	}
+}
+
+// LocationSaved publishes a new location
+func (b *Broker) LocationSaved(_ context.Context, location *models.Location) {
+	if location.Geometry == nil {
+		return
+	}
+	b.Publish(NewLocationEvent(location))
+}
+
+// KeyChanged publishes the new status of a key
+func (b *Broker) KeyChanged(info *models.KeyInfo) {
+	b.Publish(Event{Type: TypeKey, KeyID: info.ID, Time: time.Now(), Key: info})
+}
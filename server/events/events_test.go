@@ -0,0 +1,121 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func newLocation(t *testing.T, keyID string, foundAt time.Time) *models.Location {
+	t.Helper()
+	p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{8.54, 47.37})
+	if err != nil {
+		t.Fatalf("unable to create point: %v", err)
+	}
+	g := models.GeomPoint(*p)
+	return &models.Location{FoundAt: foundAt, KeyID: keyID, Geometry: &g}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	all := b.Subscribe(nil)
+	defer all.Close()
+	one := b.Subscribe([]string{"a"})
+	defer one.Close()
+
+	b.KeyChanged(&models.KeyInfo{ID: "b"})
+	b.LocationSaved(context.Background(), newLocation(t, "a", time.Now()))
+	if e := <-all.C; e.Type != TypeKey || e.KeyID != "b" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if e := <-all.C; e.Type != TypeLocation {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if e := <-one.C; e.Type != TypeLocation || e.KeyID != "a" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	// A subscription lagging behind is closed
+	for i := 0; i <= subscriptionBuffer; i++ {
+		b.KeyChanged(&models.KeyInfo{ID: "a"})
+	}
+	for range one.C {
+	}
+	if !one.Overflowed() {
+		t.Fatalf("expected the subscription to overflow")
+	}
+}
+
+func TestStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	b := NewBroker()
+	hooked := store.WithHooks(st, b)
+	foundAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var since time.Time
+	for _, l := range []*models.Location{
+		newLocation(t, "a", foundAt),
+		newLocation(t, "b", foundAt.Add(time.Minute)),
+		// Stored after the client's last event, but found before it
+		newLocation(t, "a", foundAt.Add(2*time.Minute)),
+		newLocation(t, "a", foundAt.Add(-time.Minute)),
+	} {
+		if _, err := hooked.SaveLocation(ctx, l); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+		if l.KeyID == "b" {
+			since = l.CreatedAt
+		}
+	}
+
+	received := make(chan Event, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- Stream(ctx, st, b, []string{"a"}, since, func(e Event) error {
+			received <- e
+			return nil
+		})
+	}()
+	// The locations stored after since are replayed in the order they were stored
+	for _, want := range []time.Time{foundAt.Add(2 * time.Minute), foundAt.Add(-time.Minute)} {
+		if e := <-received; !e.Time.Equal(want) || !e.Cursor.After(since) {
+			t.Fatalf("expected the location found at %s to be replayed, got %+v", want, e)
+		}
+	}
+
+	// The live locations follow, the other keys' are filtered out
+	for _, l := range []*models.Location{
+		newLocation(t, "b", foundAt.Add(3*time.Minute)),
+		newLocation(t, "a", foundAt.Add(4*time.Minute)),
+	} {
+		if _, err := hooked.SaveLocation(ctx, l); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+	}
+	select {
+	case e := <-received:
+		if e.KeyID != "a" || !e.Time.Equal(foundAt.Add(4*time.Minute)) {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no live event received")
+	}
+
+	cancel()
+	if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+}
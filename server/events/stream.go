@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// MaxReplay caps the number of stored locations replayed when resuming
+const MaxReplay = 10000
+
+// ErrOverflow is returned by Stream when the client doesn't receive the
+// events fast enough, it can resume from the last event it received
+var ErrOverflow = errors.New("events not received fast enough, resume from the last event")
+
+type locationKey struct {
+	keyID   string
+	foundAt time.Time
+}
+
+// Stream calls send with the events of keyIDs until ctx is done or send
+// fails. If since isn't zero, the locations stored after the cursor since
+// are sent first, in the order they were stored.
+func Stream(ctx context.Context, st store.Store, b *Broker, keyIDs []string, since time.Time, send func(Event) error) error {
+	// Subscribes before replaying to not miss the locations stored meanwhile
+	sub := b.Subscribe(keyIDs)
+	defer sub.Close()
+
+	replayed := map[locationKey]bool{}
+	if !since.IsZero() {
+		events, err := Replay(ctx, st, keyIDs, since)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			replayed[locationKey{e.KeyID, e.Time.UTC()}] = true
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-sub.C:
+			if !ok {
+				if sub.Overflowed() {
+					return ErrOverflow
+				}
+				return nil
+			}
+			if e.Type == TypeLocation && replayed[locationKey{e.KeyID, e.Time.UTC()}] {
+				continue
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Replay returns the location events of keyIDs stored after the cursor
+// since, in the order they were stored, at most MaxReplay of them (the most
+// recent ones). The reports fetched late are replayed even if they were
+// found before since. The locations stored before the cursor was recorded
+// have their found time as cursor, they're replayed by report time.
+func Replay(ctx context.Context, st store.Store, keyIDs []string, since time.Time) ([]Event, error) {
+	locations, err := st.LocationsCreatedAfter(ctx, keyIDs, since, MaxReplay)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(locations)
+	events := make([]Event, 0, len(locations))
+	for i := range locations {
+		events = append(events, NewLocationEvent(&locations[i]))
+	}
+	return events, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go/server/export"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// exportHistory renders the location history of a key as GeoJSON, GPX or
+// KML (format parameter, default: geojson), optionally filtered with the
+// from and to parameters (RFC3339)
+func (s *Server) exportHistory(c *gin.Context) {
+	keyID := dirtyKeyID(c.Param("keyId"))
+	if _, ok := s.keyMap[keyID]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	format, err := export.ParseFormat(c.DefaultQuery("format", string(export.GeoJSON)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, to, err := parseHistoryInterval(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	track, err := ExportTrack(c.Request.Context(), s.store, keyID, from, to)
+	if err != nil {
+		logger.Errorf("unable to export history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to export history"})
+		return
+	}
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", cleanedKeyID(keyID)+"."+format.Extension()))
+	c.Status(http.StatusOK)
+	if err := export.Write(c.Writer, format, *track); err != nil {
+		logger.Errorf("unable to write export: %v", err)
+	}
+}
+
+// parseHistoryInterval returns the interval requested via the optional
+// "from" and "to" query parameters (RFC3339), the whole history by default
+func parseHistoryInterval(c *gin.Context) (time.Time, time.Time, error) {
+	var from time.Time
+	to := time.Now()
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be a RFC3339 timestamp")
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be a RFC3339 timestamp")
+		}
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+// ExportTrack returns the track of keyID between from and to, named after
+// the alias of the key if it has one
+func ExportTrack(ctx context.Context, st store.Store, keyID string, from time.Time, to time.Time) (*export.Track, error) {
+	locations, err := st.Locations(ctx, keyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	name := cleanedKeyID(keyID)
+	aliases, err := st.KeyAliases(ctx, []string{keyID})
+	if err != nil {
+		return nil, err
+	}
+	if len(aliases) > 0 && aliases[0].Alias != "" {
+		name = aliases[0].Alias
+	}
+	track := export.NewTrack(name, locations)
+	return &track, nil
+}
@@ -0,0 +1,109 @@
+// Package export renders the location history of a key as GeoJSON, GPX or KML
+package export
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+// Format is an export file format
+type Format string
+
+const (
+	GeoJSON Format = "geojson"
+	GPX     Format = "gpx"
+	KML     Format = "kml"
+)
+
+// creator identifies the exports' producer
+const creator = "searchparty-go"
+
+// ParseFormat returns the format named s
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case GeoJSON, GPX, KML:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected geojson, gpx or kml", s)
+	}
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case GeoJSON:
+		return "application/geo+json"
+	case GPX:
+		return "application/gpx+xml"
+	case KML:
+		return "application/vnd.google-earth.kml+xml"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Extension returns the file extension of the format, without the dot
+func (f Format) Extension() string {
+	return string(f)
+}
+
+// Point is a location of a track
+type Point struct {
+	Time       time.Time
+	ReportedAt time.Time
+	Lat        float64
+	Lng        float64
+	Confidence int
+	Status     int
+}
+
+// Track is the location history of a key, oldest point first
+type Track struct {
+	Name   string
+	Points []Point
+}
+
+// NewTrack returns the track of the locations, sorted oldest first
+func NewTrack(name string, locations []models.Location) Track {
+	t := Track{Name: name, Points: make([]Point, 0, len(locations))}
+	for _, l := range locations {
+		if l.Geometry == nil {
+			continue
+		}
+		t.Points = append(t.Points, Point{
+			Time:       l.FoundAt,
+			ReportedAt: l.ReportedAt,
+			Lat:        l.Geometry.Coords().Y(),
+			Lng:        l.Geometry.Coords().X(),
+			Confidence: l.Confidence,
+			Status:     l.Status,
+		})
+	}
+	sort.Slice(t.Points, func(i, j int) bool {
+		return t.Points[i].Time.Before(t.Points[j].Time)
+	})
+	return t
+}
+
+// Write renders t to w in format f
+func Write(w io.Writer, f Format, t Track) error {
+	switch f {
+	case GeoJSON:
+		return WriteGeoJSON(w, t)
+	case GPX:
+		return WriteGPX(w, t)
+	case KML:
+		return WriteKML(w, t)
+	default:
+		return fmt.Errorf("unknown format %q", f)
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
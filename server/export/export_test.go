@@ -0,0 +1,101 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTrack() Track {
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	return Track{
+		Name: "tag",
+		Points: []Point{
+			{Time: base, ReportedAt: base.Add(time.Minute), Lat: 47.3779, Lng: 8.5403, Confidence: 2, Status: 4},
+			{Time: base.Add(time.Hour), ReportedAt: base.Add(61 * time.Minute), Lat: 46.949, Lng: 7.4392, Confidence: 3},
+		},
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteGeoJSON(&buf, testTrack()); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != 3 {
+		t.Fatalf("unexpected feature collection: %s", buf.String())
+	}
+	if fc.Features[0].Geometry.Type != "LineString" || fc.Features[1].Geometry.Type != "Point" {
+		t.Fatalf("unexpected geometries: %s", buf.String())
+	}
+	if string(fc.Features[1].Geometry.Coordinates) != "[8.5403,47.3779]" {
+		t.Fatalf("expected longitude first, got %s", fc.Features[1].Geometry.Coordinates)
+	}
+	if fc.Features[1].Properties["time"] != "2024-01-01T10:00:00Z" || fc.Features[1].Properties["confidence"] != 2.0 {
+		t.Fatalf("unexpected properties: %v", fc.Features[1].Properties)
+	}
+}
+
+func TestWriteGPX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteGPX(&buf, testTrack()); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	var g gpx
+	if err := xml.Unmarshal(buf.Bytes(), &g); err != nil {
+		t.Fatalf("invalid xml: %v", err)
+	}
+	points := g.Track.Segment.Points
+	if len(points) != 2 || points[0].Lat != 47.3779 || points[0].Time != "2024-01-01T10:00:00Z" {
+		t.Fatalf("unexpected points: %s", buf.String())
+	}
+	if points[1].Extensions.Confidence != 3 {
+		t.Fatalf("unexpected extensions: %s", buf.String())
+	}
+}
+
+func TestWriteKML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteKML(&buf, testTrack()); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	var k kml
+	if err := xml.Unmarshal(buf.Bytes(), &k); err != nil {
+		t.Fatalf("invalid xml: %v", err)
+	}
+	placemarks := k.Document.Placemarks
+	if len(placemarks) != 3 || placemarks[0].LineString == nil {
+		t.Fatalf("unexpected placemarks: %s", buf.String())
+	}
+	if placemarks[1].Point.Coordinates != "8.5403,47.3779" || placemarks[1].TimeStamp.When != "2024-01-01T10:00:00Z" {
+		t.Fatalf("unexpected point: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `<Data name="confidence">`) {
+		t.Fatalf("missing confidence: %s", buf.String())
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("GPX"); err != nil || f != GPX {
+		t.Fatalf("unexpected format %q: %v", f, err)
+	}
+	if _, err := ParseFormat("csv"); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
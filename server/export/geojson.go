@@ -0,0 +1,63 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// WriteGeoJSON renders t as a FeatureCollection with a Point feature per
+// location and, if there are at least two locations, a LineString of the track
+func WriteGeoJSON(w io.Writer, t Track) error {
+	fc := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]geoJSONFeature, 0, len(t.Points)+1),
+	}
+	if len(t.Points) > 1 {
+		coordinates := make([][2]float64, 0, len(t.Points))
+		times := make([]string, 0, len(t.Points))
+		for _, p := range t.Points {
+			coordinates = append(coordinates, [2]float64{p.Lng, p.Lat})
+			times = append(times, formatTime(p.Time))
+		}
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: coordinates},
+			Properties: map[string]any{
+				"name":  t.Name,
+				"from":  formatTime(t.Points[0].Time),
+				"to":    formatTime(t.Points[len(t.Points)-1].Time),
+				"times": times,
+			},
+		})
+	}
+	for _, p := range t.Points {
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: [2]float64{p.Lng, p.Lat}},
+			Properties: map[string]any{
+				"name":       t.Name,
+				"time":       formatTime(p.Time),
+				"reportedAt": formatTime(p.ReportedAt),
+				"confidence": p.Confidence,
+				"status":     p.Status,
+			},
+		})
+	}
+	return json.NewEncoder(w).Encode(fc)
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+type gpx struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string          `xml:"name"`
+	Segment gpxTrackSegment `xml:"trkseg"`
+}
+
+type gpxTrackSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat        float64       `xml:"lat,attr"`
+	Lon        float64       `xml:"lon,attr"`
+	Time       string        `xml:"time"`
+	Extensions gpxExtensions `xml:"extensions"`
+}
+
+// gpxExtensions are namespaced, as required by the GPX schema
+type gpxExtensions struct {
+	Confidence int    `xml:"https://github.com/denysvitali/searchparty-go confidence"`
+	Status     int    `xml:"https://github.com/denysvitali/searchparty-go status"`
+	ReportedAt string `xml:"https://github.com/denysvitali/searchparty-go reportedAt"`
+}
+
+// WriteGPX renders t as a GPX 1.1 track, the confidence and the status are
+// stored as extensions of each point
+func WriteGPX(w io.Writer, t Track) error {
+	g := gpx{
+		Version: "1.1",
+		Creator: creator,
+		Track: gpxTrack{
+			Name: t.Name,
+			Segment: gpxTrackSegment{
+				Points: make([]gpxPoint, 0, len(t.Points)),
+			},
+		},
+	}
+	for _, p := range t.Points {
+		g.Track.Segment.Points = append(g.Track.Segment.Points, gpxPoint{
+			Lat:  p.Lat,
+			Lon:  p.Lng,
+			Time: formatTime(p.Time),
+			Extensions: gpxExtensions{
+				Confidence: p.Confidence,
+				Status:     p.Status,
+				ReportedAt: formatTime(p.ReportedAt),
+			},
+		})
+	}
+	return writeXML(w, g)
+}
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
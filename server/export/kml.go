@@ -0,0 +1,90 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type kml struct {
+	XMLName  xml.Name    `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name         string           `xml:"name"`
+	TimeStamp    *kmlTimeStamp    `xml:"TimeStamp,omitempty"`
+	TimeSpan     *kmlTimeSpan     `xml:"TimeSpan,omitempty"`
+	ExtendedData *kmlExtendedData `xml:"ExtendedData,omitempty"`
+	Point        *kmlGeometry     `xml:"Point,omitempty"`
+	LineString   *kmlGeometry     `xml:"LineString,omitempty"`
+}
+
+type kmlTimeStamp struct {
+	When string `xml:"when"`
+}
+
+type kmlTimeSpan struct {
+	Begin string `xml:"begin"`
+	End   string `xml:"end"`
+}
+
+type kmlExtendedData struct {
+	Data []kmlData `xml:"Data"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlGeometry struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// WriteKML renders t as a KML document with a timestamped placemark per
+// location and, if there are at least two locations, a line of the track
+func WriteKML(w io.Writer, t Track) error {
+	k := kml{Document: kmlDocument{Name: t.Name}}
+	if len(t.Points) > 1 {
+		coordinates := make([]string, 0, len(t.Points))
+		for _, p := range t.Points {
+			coordinates = append(coordinates, kmlCoordinates(p))
+		}
+		k.Document.Placemarks = append(k.Document.Placemarks, kmlPlacemark{
+			Name: t.Name,
+			TimeSpan: &kmlTimeSpan{
+				Begin: formatTime(t.Points[0].Time),
+				End:   formatTime(t.Points[len(t.Points)-1].Time),
+			},
+			LineString: &kmlGeometry{Coordinates: strings.Join(coordinates, " ")},
+		})
+	}
+	for _, p := range t.Points {
+		k.Document.Placemarks = append(k.Document.Placemarks, kmlPlacemark{
+			Name:      formatTime(p.Time),
+			TimeStamp: &kmlTimeStamp{When: formatTime(p.Time)},
+			ExtendedData: &kmlExtendedData{Data: []kmlData{
+				{Name: "confidence", Value: strconv.Itoa(p.Confidence)},
+				{Name: "status", Value: strconv.Itoa(p.Status)},
+				{Name: "reportedAt", Value: formatTime(p.ReportedAt)},
+			}},
+			Point: &kmlGeometry{Coordinates: kmlCoordinates(p)},
+		})
+	}
+	return writeXML(w, k)
+}
+
+func kmlCoordinates(p Point) string {
+	return fmt.Sprintf("%s,%s",
+		strconv.FormatFloat(p.Lng, 'f', -1, 64),
+		strconv.FormatFloat(p.Lat, 'f', -1, 64),
+	)
+}
@@ -0,0 +1,102 @@
+package geofence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "geofence")
+
+// EventHandler is called for every geofence event generated
+type EventHandler func(ctx context.Context, geofence *models.Geofence, event *models.GeofenceEvent)
+
+// Evaluator checks the new locations against the geofences and stores the
+// events they generate. It implements store.LocationHook.
+type Evaluator struct {
+	st store.Store
+
+	mu       sync.Mutex
+	handlers []EventHandler
+}
+
+// New returns an Evaluator using the geofences of st
+func New(st store.Store) *Evaluator {
+	return &Evaluator{st: st}
+}
+
+// OnEvent registers h to be called for every event
+func (e *Evaluator) OnEvent(h EventHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handlers = append(e.handlers, h)
+}
+
+// LocationSaved evaluates location, errors are only logged
+func (e *Evaluator) LocationSaved(ctx context.Context, location *models.Location) {
+	if err := e.Evaluate(ctx, location); err != nil {
+		logger.Errorf("unable to evaluate geofences for %s: %v", location.KeyID, err)
+	}
+}
+
+// Evaluate updates the state of the key of location in the geofences that
+// apply to it and stores the resulting events
+func (e *Evaluator) Evaluate(ctx context.Context, location *models.Location) error {
+	if location.Geometry == nil {
+		return nil
+	}
+	geofences, err := e.st.Geofences(ctx)
+	if err != nil {
+		return err
+	}
+	lat := location.Geometry.Coords().Y()
+	lng := location.Geometry.Coords().X()
+
+	// Serializes the state updates of concurrent fetches
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range geofences {
+		g := &geofences[i]
+		if g.KeyID != nil && *g.KeyID != location.KeyID {
+			continue
+		}
+		state, err := e.st.GeofenceState(ctx, g.ID, location.KeyID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			state = &models.GeofenceState{GeofenceID: g.ID, KeyID: location.KeyID}
+		case err != nil:
+			return err
+		}
+		types, ok := transition(g, state, Contains(g, lat, lng), location.FoundAt)
+		if !ok {
+			continue
+		}
+		if err := e.st.SaveGeofenceState(ctx, state); err != nil {
+			return err
+		}
+		for _, t := range types {
+			event := &models.GeofenceEvent{
+				GeofenceID: g.ID,
+				KeyID:      location.KeyID,
+				Type:       t,
+				FoundAt:    location.FoundAt,
+				Lat:        lat,
+				Lng:        lng,
+			}
+			if err := e.st.SaveGeofenceEvent(ctx, event); err != nil {
+				return fmt.Errorf("geofence %d: %w", g.ID, err)
+			}
+			logger.Infof("%s %s geofence %q", location.KeyID, t, g.Name)
+			for _, h := range e.handlers {
+				h(ctx, g, event)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package geofence generates enter, exit and dwell events when the stored
+// locations cross the configured geofences
+package geofence
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// maxPolygonVertices caps the size of a polygon geofence
+const maxPolygonVertices = 1000
+
+// Validate checks that g describes a valid circle or polygon
+func Validate(g *models.Geofence) error {
+	if g.Name == "" {
+		return errors.New("name is required")
+	}
+	if g.DwellSeconds < 0 {
+		return errors.New("dwellSeconds must not be negative")
+	}
+	switch g.Type {
+	case models.GeofenceCircle:
+		if !store.ValidLatLng(g.Lat, g.Lng) {
+			return errors.New("invalid circle center")
+		}
+		if !(g.Radius > 0) || math.IsInf(g.Radius, 0) {
+			return errors.New("radius must be a positive number")
+		}
+		g.Polygon = nil
+	case models.GeofencePolygon:
+		if len(g.Polygon) < 3 || len(g.Polygon) > maxPolygonVertices {
+			return fmt.Errorf("a polygon needs between 3 and %d vertices", maxPolygonVertices)
+		}
+		for _, p := range g.Polygon {
+			if !store.ValidLatLng(p[1], p[0]) {
+				return fmt.Errorf("invalid polygon vertex [%v, %v]", p[0], p[1])
+			}
+		}
+		g.Lat, g.Lng, g.Radius = 0, 0, 0
+	default:
+		return fmt.Errorf("unknown geofence type %q, expected %s or %s",
+			g.Type, models.GeofenceCircle, models.GeofencePolygon)
+	}
+	return nil
+}
+
+// Contains returns whether the point is inside g
+func Contains(g *models.Geofence, lat float64, lng float64) bool {
+	switch g.Type {
+	case models.GeofenceCircle:
+		return store.Distance(g.Lat, g.Lng, lat, lng) <= g.Radius
+	case models.GeofencePolygon:
+		return polygonContains(g.Polygon, lat, lng)
+	default:
+		return false
+	}
+}
+
+// polygonContains casts a ray from the point and counts the edges of the
+// polygon ([lng, lat] vertices) it crosses
+func polygonContains(polygon [][2]float64, lat float64, lng float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		xi, yi := polygon[i][0], polygon[i][1]
+		xj, yj := polygon[j][0], polygon[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// transition updates state with a location of its key found at foundAt and
+// returns the events it generates. Locations not newer than the last one
+// evaluated are ignored, ok is false for them.
+func transition(g *models.Geofence, state *models.GeofenceState, inside bool, foundAt time.Time) (events []string, ok bool) {
+	if !state.LastFoundAt.IsZero() && !foundAt.After(state.LastFoundAt) {
+		return nil, false
+	}
+	state.LastFoundAt = foundAt
+
+	switch {
+	case inside && !state.Inside:
+		state.Inside = true
+		state.EnteredAt = &foundAt
+		state.DwellNotified = false
+		events = append(events, models.GeofenceEnter)
+	case !inside && state.Inside:
+		state.Inside = false
+		state.EnteredAt = nil
+		state.DwellNotified = false
+		return []string{models.GeofenceExit}, true
+	case !inside:
+		return nil, true
+	}
+
+	dwell := time.Duration(g.DwellSeconds) * time.Second
+	if dwell > 0 && !state.DwellNotified && state.EnteredAt != nil && foundAt.Sub(*state.EnteredAt) >= dwell {
+		state.DwellNotified = true
+		events = append(events, models.GeofenceDwell)
+	}
+	return events, true
+}
@@ -0,0 +1,157 @@
+package geofence
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func TestContains(t *testing.T) {
+	circle := &models.Geofence{Type: models.GeofenceCircle, Lat: 47.3769, Lng: 8.5417, Radius: 500}
+	square := &models.Geofence{Type: models.GeofencePolygon, Polygon: [][2]float64{
+		{8.5, 47.3}, {8.6, 47.3}, {8.6, 47.4}, {8.5, 47.4},
+	}}
+	tests := []struct {
+		name     string
+		geofence *models.Geofence
+		lat, lng float64
+		want     bool
+	}{
+		{"circle center", circle, 47.3769, 8.5417, true},
+		{"circle inside", circle, 47.3790, 8.5417, true},
+		{"circle outside", circle, 47.3900, 8.5417, false},
+		{"polygon inside", square, 47.35, 8.55, true},
+		{"polygon outside lat", square, 47.45, 8.55, false},
+		{"polygon outside lng", square, 47.35, 8.65, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Contains(tt.geofence, tt.lat, tt.lng); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []models.Geofence{
+		{Type: models.GeofenceCircle, Lat: 47, Lng: 8, Radius: 100},
+		{Name: "a", Type: models.GeofenceCircle, Lat: 47, Lng: 8},
+		{Name: "a", Type: models.GeofenceCircle, Lat: 91, Lng: 8, Radius: 100},
+		{Name: "a", Type: models.GeofenceCircle, Lat: 47, Lng: 8, Radius: math.NaN()},
+		{Name: "a", Type: models.GeofenceCircle, Lat: 47, Lng: 8, Radius: math.Inf(1)},
+		{Name: "a", Type: models.GeofencePolygon, Polygon: [][2]float64{{8, 47}, {9, 47}}},
+		{Name: "a", Type: "square"},
+	}
+	for _, g := range invalid {
+		if err := Validate(&g); err == nil {
+			t.Fatalf("expected %+v to be invalid", g)
+		}
+	}
+	g := models.Geofence{Name: "home", Type: models.GeofenceCircle, Lat: 47, Lng: 8, Radius: 100}
+	if err := Validate(&g); err != nil {
+		t.Fatalf("expected a valid geofence, got %v", err)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	g := &models.Geofence{DwellSeconds: 600}
+	state := &models.GeofenceState{}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		inside  bool
+		offset  time.Duration
+		want    []string
+		ignored bool
+	}{
+		{false, 0, nil, false},
+		{true, time.Minute, []string{models.GeofenceEnter}, false},
+		{true, 5 * time.Minute, nil, false},
+		// Out of order locations are ignored
+		{false, 2 * time.Minute, nil, true},
+		{true, 11 * time.Minute, []string{models.GeofenceDwell}, false},
+		{true, 20 * time.Minute, nil, false},
+		{false, 30 * time.Minute, []string{models.GeofenceExit}, false},
+		{false, 40 * time.Minute, nil, false},
+	}
+	for i, step := range steps {
+		got, ok := transition(g, state, step.inside, start.Add(step.offset))
+		if ok == step.ignored {
+			t.Fatalf("step %d: expected ignored=%v", i, step.ignored)
+		}
+		if !slices.Equal(got, step.want) {
+			t.Fatalf("step %d: expected %v, got %v", i, step.want, got)
+		}
+	}
+}
+
+func TestEvaluator(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	other := "other"
+	geofences := []*models.Geofence{
+		{Name: "home", Type: models.GeofenceCircle, Lat: 47.3769, Lng: 8.5417, Radius: 500},
+		{Name: "other's home", KeyID: &other, Type: models.GeofenceCircle, Lat: 47.3769, Lng: 8.5417, Radius: 500},
+	}
+	for _, g := range geofences {
+		if err := st.SaveGeofence(ctx, g); err != nil {
+			t.Fatalf("unable to save geofence: %v", err)
+		}
+	}
+
+	e := New(st)
+	var handled []string
+	e.OnEvent(func(_ context.Context, g *models.Geofence, event *models.GeofenceEvent) {
+		handled = append(handled, g.Name+" "+event.Type)
+	})
+	hooked := store.WithHooks(st, e)
+
+	start := time.Now().Truncate(time.Second).Add(-time.Hour)
+	for i, lat := range []float64{47.40, 47.3769, 47.3770, 47.40} {
+		p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{8.5417, lat})
+		if err != nil {
+			t.Fatalf("unable to create point: %v", err)
+		}
+		g := models.GeomPoint(*p)
+		l := &models.Location{FoundAt: start.Add(time.Duration(i) * time.Minute), KeyID: "key", Geometry: &g}
+		if _, err := hooked.SaveLocation(ctx, l); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+		// Duplicates don't reach the hooks
+		if _, err := hooked.SaveLocation(ctx, l); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+	}
+
+	if want := []string{"home enter", "home exit"}; !slices.Equal(handled, want) {
+		t.Fatalf("expected events %v, got %v", want, handled)
+	}
+	events, err := st.GeofenceEvents(ctx, store.GeofenceEventQuery{KeyID: "key"})
+	if err != nil {
+		t.Fatalf("unable to get events: %v", err)
+	}
+	if len(events) != 2 || events[0].Type != models.GeofenceExit || events[1].Type != models.GeofenceEnter {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	if err := st.DeleteGeofence(ctx, geofences[0].ID); err != nil {
+		t.Fatalf("unable to delete geofence: %v", err)
+	}
+	events, err = st.GeofenceEvents(ctx, store.GeofenceEventQuery{GeofenceID: geofences[0].ID})
+	if err != nil || len(events) != 0 {
+		t.Fatalf("expected the events to be deleted with the geofence, got %v %v", events, err)
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go/server/geofence"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// maxGeofenceEvents caps the number of events returned at once
+const maxGeofenceEvents = 1000
+
+func (s *Server) getGeofences(c *gin.Context) {
+	geofences, err := s.store.Geofences(c.Request.Context())
+	if err != nil {
+		logger.Errorf("unable to get geofences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to get geofences"})
+		return
+	}
+	if geofences == nil {
+		geofences = []models.Geofence{}
+	}
+	c.JSON(http.StatusOK, geofences)
+}
+
+func (s *Server) getGeofence(c *gin.Context) {
+	id, ok := geofenceID(c)
+	if !ok {
+		return
+	}
+	g, err := s.store.Geofence(c.Request.Context(), id)
+	if err != nil {
+		s.geofenceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, g)
+}
+
+// saveGeofence creates a geofence, or replaces it when called with an id
+func (s *Server) saveGeofence(c *gin.Context) {
+	var g models.Geofence
+	if err := c.ShouldBindJSON(&g); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status := http.StatusCreated
+	g.ID = 0
+	if c.Param("id") != "" {
+		id, ok := geofenceID(c)
+		if !ok {
+			return
+		}
+		g.ID = id
+		status = http.StatusOK
+	}
+	if g.KeyID != nil {
+		keyID := dirtyKeyID(*g.KeyID)
+		if _, ok := s.keyMap[keyID]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("key %q not found", *g.KeyID)})
+			return
+		}
+		g.KeyID = &keyID
+	}
+	if err := geofence.Validate(&g); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.store.SaveGeofence(c.Request.Context(), &g); err != nil {
+		s.geofenceError(c, err)
+		return
+	}
+	// Returns the stored geofence, with its creation time
+	saved, err := s.store.Geofence(c.Request.Context(), g.ID)
+	if err != nil {
+		s.geofenceError(c, err)
+		return
+	}
+	c.JSON(status, saved)
+}
+
+func (s *Server) deleteGeofence(c *gin.Context) {
+	id, ok := geofenceID(c)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteGeofence(c.Request.Context(), id); err != nil {
+		s.geofenceError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getGeofenceEvents returns the geofence events, newest first, optionally
+// filtered by key, geofence and found time (from, to as RFC3339)
+func (s *Server) getGeofenceEvents(c *gin.Context) {
+	q := store.GeofenceEventQuery{Limit: maxGeofenceEvents}
+	if key := c.Query("key"); key != "" {
+		q.KeyID = dirtyKeyID(key)
+	}
+	if id := c.Query("geofence"); id != "" {
+		v, err := strconv.ParseUint(id, 10, 0)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "geofence must be an id"})
+			return
+		}
+		q.GeofenceID = uint(v)
+	}
+	var err error
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := c.Query(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a RFC3339 timestamp"})
+				return
+			}
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxGeofenceEvents {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxGeofenceEvents)})
+			return
+		}
+		q.Limit = l
+	}
+	events, err := s.store.GeofenceEvents(c.Request.Context(), q)
+	if err != nil {
+		logger.Errorf("unable to get geofence events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to get geofence events"})
+		return
+	}
+	if events == nil {
+		events = []models.GeofenceEvent{}
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+func geofenceID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid geofence id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (s *Server) geofenceError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "geofence not found"})
+		return
+	}
+	logger.Errorf("geofence request failed: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "geofence request failed"})
+}
@@ -0,0 +1,98 @@
+// Package ingest fetches the reports of the keys, decodes them and stores
+// the resulting locations
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/model"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "ingest")
+
+// Fetch finds the reports of key published between from and to (now if
+// zero) and stores them, returning the decoded ones
+func Fetch(ctx context.Context, finder searchparty.Finder, st store.Store, key model.MainKey, from time.Time, to time.Time) ([]searchparty.TagData, error) {
+	reports, subKeysMap, err := finder.FindRange(ctx, []model.MainKey{key}, searchparty.FindOptions{
+		From:   from,
+		To:     to,
+		LostAt: LostAt(ctx, st, key.ID()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Reports(ctx, st, reports, subKeysMap), nil
+}
+
+// Reports decodes and stores the reports, returning the decoded ones.
+// Reports that were already stored are ignored. Apple returns the reports in
+// no particular order, they're stored from the oldest to the newest so that
+// the hooks (e.g. the geofences) see the locations in order.
+func Reports(ctx context.Context, st store.Store, reports []searchparty.Report, subKeysMap map[string]model.SubKey) []searchparty.TagData {
+	decoded := make([]decodedReport, 0, len(reports))
+	for _, r := range reports {
+		key, ok := subKeysMap[r.ID]
+		if !ok {
+			logger.Errorf("unable to find key for report %s", r.ID)
+			continue
+		}
+		td, err := searchparty.DecodeReport(r, key)
+		if err != nil {
+			logger.Errorf("unable to decode report: %v", err)
+			continue
+		}
+		location, err := models.NewLocation(r, key, td)
+		if err != nil {
+			logger.Errorf("unable to create location: %v", err)
+			continue
+		}
+		decoded = append(decoded, decodedReport{tagData: td, location: location})
+	}
+	return save(ctx, st, decoded)
+}
+
+type decodedReport struct {
+	tagData  *searchparty.TagData
+	location *models.Location
+}
+
+// save stores the locations of decoded sorted by FoundAt, returning the tag
+// data of the ones that could be saved
+func save(ctx context.Context, st store.Store, decoded []decodedReport) []searchparty.TagData {
+	sort.SliceStable(decoded, func(i, j int) bool {
+		return decoded[i].location.FoundAt.Before(decoded[j].location.FoundAt)
+	})
+	tagData := make([]searchparty.TagData, 0, len(decoded))
+	for _, d := range decoded {
+		if _, err := st.SaveLocation(ctx, d.location); err != nil {
+			logger.Errorf("unable to save location: %v", err)
+			continue
+		}
+		tagData = append(tagData, *d.tagData)
+	}
+	return tagData
+}
+
+// LostAt returns the time keyID was marked as lost, or the zero time if it
+// isn't lost
+func LostAt(ctx context.Context, st store.Store, keyID string) time.Time {
+	k, err := st.KeyInfo(ctx, keyID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			logger.Errorf("unable to fetch key info: %v", err)
+		}
+		return time.Time{}
+	}
+	if k.LostAt == nil {
+		return time.Time{}
+	}
+	return *k.LostAt
+}
@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/server/geofence"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func TestSaveUnorderedBatch(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+	if err := st.SaveGeofence(ctx, &models.Geofence{Name: "home", Type: models.GeofenceCircle, Lat: 47.3769, Lng: 8.5417, Radius: 500}); err != nil {
+		t.Fatalf("unable to save geofence: %v", err)
+	}
+	e := geofence.New(st)
+	var handled []string
+	e.OnEvent(func(_ context.Context, g *models.Geofence, event *models.GeofenceEvent) {
+		handled = append(handled, g.Name+" "+event.Type)
+	})
+	hooked := store.WithHooks(st, e)
+
+	// The key leaves, enters and leaves the geofence again, but Apple
+	// returns the reports newest first
+	start := time.Now().Truncate(time.Second).Add(-time.Hour)
+	lats := []float64{47.40, 47.3769, 47.3770, 47.40}
+	var decoded []decodedReport
+	for i := len(lats) - 1; i >= 0; i-- {
+		p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{8.5417, lats[i]})
+		if err != nil {
+			t.Fatalf("unable to create point: %v", err)
+		}
+		g := models.GeomPoint(*p)
+		foundAt := start.Add(time.Duration(i) * time.Minute)
+		decoded = append(decoded, decodedReport{
+			tagData:  &searchparty.TagData{Time: foundAt, Lat: lats[i], Lng: 8.5417},
+			location: &models.Location{FoundAt: foundAt, KeyID: "key", Geometry: &g},
+		})
+	}
+
+	tagData := save(ctx, hooked, decoded)
+	if len(tagData) != len(lats) {
+		t.Fatalf("expected %d saved reports, got %d", len(lats), len(tagData))
+	}
+	for i := 1; i < len(tagData); i++ {
+		if !tagData[i-1].Time.Before(tagData[i].Time) {
+			t.Fatalf("reports not saved in order: %s before %s", tagData[i-1].Time, tagData[i].Time)
+		}
+	}
+	if want := []string{"home enter", "home exit"}; !slices.Equal(handled, want) {
+		t.Fatalf("expected events %v, got %v", want, handled)
+	}
+}
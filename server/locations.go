@@ -1,10 +1,30 @@
 package server
 
 import (
+	"time"
+
 	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/server/models"
 )
 
 type Location struct {
 	PublishedAt string              `json:"publishedAt"`
 	TagData     searchparty.TagData `json:"tagData"`
 }
+
+func newLocation(l models.Location) Location {
+	return Location{
+		PublishedAt: l.ReportedAt.Format(time.RFC3339),
+		TagData: searchparty.NewTagData(
+			l.FoundAt,
+			l.Geometry.Coords().Y(),
+			l.Geometry.Coords().X(),
+			l.Confidence,
+			l.DecodedStatus(),
+		),
+	}
+}
+
+func newLocationResult(l models.Location) models.LocationResult {
+	return models.NewLocationResult(&l)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+// KeyLostHandler is called when a key is marked as lost
+type KeyLostHandler func(ctx context.Context, keyID string, lostAt time.Time)
+
+// OnKeyLost registers h to be called when a key is marked as lost
+func (s *Server) OnKeyLost(h KeyLostHandler) {
+	s.keyLostHandlers = append(s.keyLostHandlers, h)
+}
+
+type lostRequest struct {
+	// LostAt defaults to now
+	LostAt *time.Time `json:"lostAt"`
+}
+
+// setLost marks a key as lost, its reports are fetched since then
+func (s *Server) setLost(c *gin.Context) {
+	keyID := dirtyKeyID(c.Param("keyId"))
+	if _, ok := s.keyMap[keyID]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	var req lostRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	lostAt := time.Now()
+	if req.LostAt != nil {
+		lostAt = *req.LostAt
+	}
+	if lostAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lostAt must not be in the future"})
+		return
+	}
+	s.saveLostAt(c, keyID, &lostAt)
+}
+
+// clearLost marks a key as found
+func (s *Server) clearLost(c *gin.Context) {
+	keyID := dirtyKeyID(c.Param("keyId"))
+	if _, ok := s.keyMap[keyID]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	s.saveLostAt(c, keyID, nil)
+}
+
+func (s *Server) saveLostAt(c *gin.Context, keyID string, lostAt *time.Time) {
+	ctx := c.Request.Context()
+	if err := s.store.SetLostAt(ctx, keyID, lostAt); err != nil {
+		logger.Errorf("unable to save lost time: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to save lost time"})
+		return
+	}
+	if s.scheduler != nil {
+		s.scheduler.reschedule(ctx, keyID)
+	}
+	s.keyChanged(ctx, keyID)
+	if lostAt != nil {
+		for _, h := range s.keyLostHandlers {
+			h(ctx, keyID, *lostAt)
+		}
+	}
+	c.JSON(http.StatusOK, models.KeyInfo{ID: keyID, LostAt: lostAt})
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+const (
+	GeofenceCircle  = "circle"
+	GeofencePolygon = "polygon"
+)
+
+// Geofence is a named area, either a circle or a polygon, that generates
+// events when a key enters, leaves or stays in it
+type Geofence struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `json:"name"`
+	// KeyID restricts the geofence to a key, nil applies it to all the keys
+	KeyID *string `gorm:"index:idx_geofence_key_id" json:"keyId,omitempty"`
+	// Type is GeofenceCircle or GeofencePolygon
+	Type string `json:"type"`
+	// Lat, Lng and Radius (in meters) describe a circle
+	Lat    float64 `json:"lat,omitempty"`
+	Lng    float64 `json:"lng,omitempty"`
+	Radius float64 `json:"radius,omitempty"`
+	// Polygon is the list of [lng, lat] vertices of a polygon
+	Polygon [][2]float64 `gorm:"serializer:json" json:"polygon,omitempty"`
+	// DwellSeconds is how long a key has to stay in the geofence to
+	// generate a dwell event, 0 disables dwell events
+	DwellSeconds int       `json:"dwellSeconds,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+const (
+	GeofenceEnter = "enter"
+	GeofenceExit  = "exit"
+	GeofenceDwell = "dwell"
+)
+
+// GeofenceEvent is generated when a key enters, leaves or dwells in a geofence
+type GeofenceEvent struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	GeofenceID uint   `gorm:"index:idx_geofence_event_geofence_id" json:"geofenceId"`
+	KeyID      string `gorm:"index:idx_geofence_event_key_id" json:"keyId"`
+	// Type is GeofenceEnter, GeofenceExit or GeofenceDwell
+	Type string `json:"type"`
+	// FoundAt is the time of the location that generated the event
+	FoundAt   time.Time `gorm:"index:idx_geofence_event_found_at" json:"foundAt"`
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// GeofenceState tracks whether a key is in a geofence
+type GeofenceState struct {
+	GeofenceID uint   `gorm:"primaryKey"`
+	KeyID      string `gorm:"primaryKey"`
+	Inside     bool
+	// EnteredAt is when the key entered the geofence
+	EnteredAt *time.Time
+	// DwellNotified is set once the dwell event was generated
+	DwellNotified bool
+	// LastFoundAt is the time of the last location evaluated, older
+	// locations are ignored
+	LastFoundAt time.Time
+}
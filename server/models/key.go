@@ -6,4 +6,11 @@ type KeyInfo struct {
 	ID     string     `gorm:"primaryKey" json:"id"`
 	Alias  *KeyAlias  `gorm:"foreignKey:KeyID;references:ID" json:"alias"`
 	LostAt *time.Time `json:"lostAt"`
+	// LastFetchedAt is the end of the last window successfully fetched by the scheduler
+	LastFetchedAt *time.Time `json:"lastFetchedAt"`
+	// MinPollSeconds and MaxPollSeconds override the scheduler's polling bounds for the key
+	MinPollSeconds *int `json:"minPollSeconds"`
+	MaxPollSeconds *int `json:"maxPollSeconds"`
+	// TraccarEnabled forwards the new locations of the key to Traccar
+	TraccarEnabled bool `json:"traccarEnabled"`
 }
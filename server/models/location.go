@@ -2,11 +2,16 @@ package models
 
 import (
 	"database/sql/driver"
+	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"time"
 
 	"github.com/twpayne/go-geom"
 	"github.com/twpayne/go-geom/encoding/ewkb"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/model"
 )
 
 type GeomPoint geom.Point
@@ -19,18 +24,35 @@ func (g GeomPoint) Value() (driver.Value, error) {
 	return ewkbPt.Value()
 }
 
-// Scan scan value into geom.Point, implements sql.Scanner interface
+// Scan scan value into geom.Point, implements sql.Scanner interface.
+// PostGIS returns the EWKB hex encoded, SQLite returns the raw bytes.
 func (g *GeomPoint) Scan(value interface{}) error {
-	t, err := hex.DecodeString(string(value.([]byte)))
-	if err != nil {
-		return err
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unsupported geometry value %T", value)
 	}
-	gt, err := ewkb.Unmarshal(t)
+	// The first byte of a raw EWKB is the byte order (0 or 1)
+	if len(b) > 0 && b[0] > 1 {
+		t, err := hex.DecodeString(string(b))
+		if err != nil {
+			return err
+		}
+		b = t
+	}
+	gt, err := ewkb.Unmarshal(b)
 	if err != nil {
 		return err
 	}
-	p := GeomPoint(*gt.(*geom.Point))
-	*g = p
+	p, ok := gt.(*geom.Point)
+	if !ok {
+		return fmt.Errorf("unexpected geometry %T", gt)
+	}
+	*g = GeomPoint(*p)
 
 	return nil
 }
@@ -44,6 +66,56 @@ type Location struct {
 	Confidence      int        `gorm:"index:idx_confidence"`
 	Status          int
 	CurrentKeyID    string `gorm:"index:idx_current_key_id"`
+	// CreatedAt is when the location was stored, it increases with every
+	// location stored by the server. It's the found time for the locations
+	// stored before it was recorded.
+	CreatedAt time.Time `gorm:"index:idx_created_at"`
+}
+
+// NewLocation builds the Location row stored for a decoded report
+func NewLocation(r searchparty.Report, key model.SubKey, td *searchparty.TagData) (*Location, error) {
+	payloadBytes, err := base64.StdEncoding.DecodeString(r.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode payload: %w", err)
+	}
+	p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{td.Lng, td.Lat}) //nolint:mnd
+	if err != nil {
+		return nil, fmt.Errorf("unable to create point: %w", err)
+	}
+	dbPoint := GeomPoint(*p)
+	return &Location{
+		ReportedAt:      time.Unix(r.DatePublished/1000, 0),
+		FoundAt:         td.Time,
+		KeyID:           key.MainKey.ID(),
+		CurrentKeyID:    base64.StdEncoding.EncodeToString(key.HashedAdvKey),
+		OriginalContent: payloadBytes,
+		Geometry:        &dbPoint,
+		Confidence:      td.Confidence,
+		Status:          int(td.Status),
+	}, nil
+}
+
+// MaxAccuracy is the accuracy (in meters) reported when the confidence is unknown
+const MaxAccuracy = 255
+
+// AccuracyFromConfidence converts the confidence byte of a report into an
+// accuracy radius in meters. Accessories report the horizontal accuracy
+// directly in this byte, so it only needs to be clamped to a sane value.
+func AccuracyFromConfidence(confidence int) int {
+	if confidence <= 0 {
+		return MaxAccuracy
+	}
+	return min(confidence, MaxAccuracy)
+}
+
+// Accuracy returns the accuracy radius of l in meters
+func (l *Location) Accuracy() int {
+	return AccuracyFromConfidence(l.Confidence)
+}
+
+// DecodedStatus returns the decoded status byte of l
+func (l *Location) DecodedStatus() searchparty.Status {
+	return searchparty.Status(l.Status) //nolint:gosec
 }
 
 type LocationResult struct {
@@ -54,4 +126,23 @@ type LocationResult struct {
 	Lng        float64   `json:"lng"`
 	Confidence int       `json:"confidence"`
 	Status     int       `json:"status"`
+	// Battery and Maintained are decoded from Status
+	Battery    searchparty.BatteryLevel `json:"battery"`
+	Maintained bool                     `json:"maintained"`
+}
+
+// NewLocationResult returns the API representation of l
+func NewLocationResult(l *Location) LocationResult {
+	status := l.DecodedStatus()
+	return LocationResult{
+		FoundAt:    l.FoundAt,
+		ReportedAt: l.ReportedAt,
+		KeyID:      l.KeyID,
+		Lat:        l.Geometry.Coords().Y(),
+		Lng:        l.Geometry.Coords().X(),
+		Confidence: l.Confidence,
+		Status:     l.Status,
+		Battery:    status.Battery(),
+		Maintained: status.Maintained(),
+	}
 }
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Traccar outbox statuses
+const (
+	TraccarPending = "pending"
+	TraccarFailed  = "failed"
+)
+
+// TraccarOutbox is a location waiting to be forwarded to Traccar
+type TraccarOutbox struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	KeyID    string    `gorm:"uniqueIndex:idx_traccar_outbox_location" json:"keyId"`
+	FoundAt  time.Time `gorm:"uniqueIndex:idx_traccar_outbox_location" json:"foundAt"`
+	Lat      float64   `json:"lat"`
+	Lng      float64   `json:"lng"`
+	Accuracy int       `json:"accuracy"`
+	Battery  int       `json:"battery"`
+	// Status is TraccarPending or TraccarFailed, the sent locations are removed
+	Status        string    `gorm:"index:idx_traccar_outbox_status" json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `gorm:"index:idx_traccar_outbox_next_attempt_at" json:"nextAttemptAt"`
+	LastError     string    `json:"lastError,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func (TraccarOutbox) TableName() string {
+	return "traccar_outbox"
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Webhook is an endpoint notified of the events of the keys
+type Webhook struct {
+	ID  uint   `gorm:"primaryKey" json:"id"`
+	URL string `json:"url"`
+	// Secret signs the payloads, it's never returned by the API
+	Secret string `json:"secret,omitempty"`
+	// Events restricts the events sent, all of them if empty
+	Events []string `gorm:"serializer:json" json:"events,omitempty"`
+	// KeyID restricts the events to a key, nil sends the events of all the keys
+	KeyID     *string   `json:"keyId,omitempty"`
+	Disabled  bool      `json:"disabled,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is a payload queued for a webhook and its delivery status
+type WebhookDelivery struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	WebhookID uint   `gorm:"index:idx_webhook_delivery_webhook_id" json:"webhookId"`
+	Event     string `json:"event"`
+	Payload   string `json:"payload"`
+	// Status is DeliveryPending, DeliveryDelivered or DeliveryFailed
+	Status        string    `gorm:"index:idx_webhook_delivery_status" json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	// ResponseCode is the HTTP status of the last attempt, 0 if it failed
+	// before getting a response
+	ResponseCode int        `json:"responseCode,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	DeliveredAt  *time.Time `json:"deliveredAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// isMoving returns whether any location of keyID found since from is farther
+// than movementThreshold from the last location found before from (or from
+// the first one found since from)
+func (s *Server) isMoving(ctx context.Context, keyID string, from time.Time) (bool, error) {
+	locations, err := s.store.Locations(ctx, keyID, from, time.Now())
+	if err != nil {
+		return false, err
+	}
+	// Oldest first, preceded by the last location before the window
+	slices.Reverse(locations)
+	previous, err := s.store.LastLocation(ctx, keyID, from)
+	switch {
+	case err == nil:
+		locations = append([]models.Location{*previous}, locations...)
+	case !errors.Is(err, store.ErrNotFound):
+		return false, err
+	}
+	return moved(locations, movementThreshold), nil
+}
+
+// moved returns whether any of the locations is farther than threshold
+// (in meters) from the first one
+func moved(locations []models.Location, threshold float64) bool {
+	if len(locations) < 2 || locations[0].Geometry == nil {
+		return false
+	}
+	ref := locations[0].Geometry.Coords()
+	for _, l := range locations[1:] {
+		if l.Geometry == nil {
+			continue
+		}
+		c := l.Geometry.Coords()
+		if store.Distance(ref.Y(), ref.X(), c.Y(), c.X()) > threshold {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package mqtt
+
+// discoveryDevice groups the entities of a key in Home Assistant
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// discoveryConfig is a Home Assistant MQTT discovery config
+type discoveryConfig struct {
+	// Name is nil for the main entity of the device, which takes its name
+	Name                *string         `json:"name"`
+	UniqueID            string          `json:"unique_id"`
+	ObjectID            string          `json:"object_id"`
+	Device              discoveryDevice `json:"device"`
+	AvailabilityTopic   string          `json:"availability_topic"`
+	StateTopic          string          `json:"state_topic,omitempty"`
+	JSONAttributesTopic string          `json:"json_attributes_topic,omitempty"`
+	ValueTemplate       string          `json:"value_template,omitempty"`
+	SourceType          string          `json:"source_type,omitempty"`
+	DeviceClass         string          `json:"device_class,omitempty"`
+	UnitOfMeasurement   string          `json:"unit_of_measurement,omitempty"`
+	EntityCategory      string          `json:"entity_category,omitempty"`
+}
+
+// discoveryConfigs returns the configs of the device_tracker and the
+// battery sensor of keyID by topic. alias is the friendly name of the
+// device, the key id if empty.
+func (p *Publisher) discoveryConfigs(keyID string, alias string) map[string]discoveryConfig {
+	id := "searchparty_" + topicKeyID(keyID)
+	name := alias
+	if name == "" {
+		name = keyID
+	}
+	device := discoveryDevice{
+		Identifiers:  []string{id},
+		Name:         name,
+		Manufacturer: "Apple",
+		Model:        "Find My accessory",
+	}
+	battery := "Battery"
+	stateTopic := p.Topic(keyID)
+	return map[string]discoveryConfig{
+		p.cfg.DiscoveryPrefix + "/device_tracker/" + id + "/config": {
+			UniqueID:            id,
+			ObjectID:            id,
+			Device:              device,
+			AvailabilityTopic:   p.cfg.StatusTopic,
+			JSONAttributesTopic: stateTopic,
+			SourceType:          "gps",
+		},
+		p.cfg.DiscoveryPrefix + "/sensor/" + id + "_battery/config": {
+			Name:              &battery,
+			UniqueID:          id + "_battery",
+			ObjectID:          id + "_battery",
+			Device:            device,
+			AvailabilityTopic: p.cfg.StatusTopic,
+			StateTopic:        stateTopic,
+			ValueTemplate:     "{{ value_json.battery_level }}",
+			DeviceClass:       "battery",
+			UnitOfMeasurement: "%",
+			EntityCategory:    "diagnostic",
+		},
+	}
+}
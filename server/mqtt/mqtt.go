@@ -0,0 +1,230 @@
+// Package mqtt publishes the new locations of the keys to an MQTT broker,
+// with Home Assistant discovery configs
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "mqtt")
+
+const (
+	// KeyPlaceholder is replaced with the key id in the topic templates
+	KeyPlaceholder = "{key}"
+
+	DefaultTopic           = "searchparty/" + KeyPlaceholder + "/location"
+	DefaultStatusTopic     = "searchparty/status"
+	DefaultDiscoveryPrefix = "homeassistant"
+
+	// publishTimeout bounds the wait for the broker's acknowledgement
+	publishTimeout = 10 * time.Second
+)
+
+// Config describes the broker and the topics
+type Config struct {
+	// Broker is the URL of the broker, e.g. tcp://localhost:1883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Topic is the template of the topic of the locations of a key
+	Topic string
+	// StatusTopic receives "online" and "offline" (as last will)
+	StatusTopic string
+	// DiscoveryPrefix is the Home Assistant discovery prefix, empty
+	// disables the discovery configs
+	DiscoveryPrefix string
+	QoS             byte
+	// Retain makes the broker keep the last location of every key
+	Retain bool
+}
+
+// State is the payload published for every new location, its fields are
+// the attributes Home Assistant reads for a GPS device_tracker
+type State struct {
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+	GPSAccuracy  int       `json:"gps_accuracy"`
+	BatteryLevel int       `json:"battery_level"`
+	FoundAt      time.Time `json:"found_at"`
+	ReportedAt   time.Time `json:"reported_at"`
+	Status       int       `json:"status"`
+	KeyID        string    `json:"key_id"`
+}
+
+// Publisher publishes the locations of the keys. It implements store.LocationHook.
+type Publisher struct {
+	cfg    Config
+	st     store.Store
+	keyIDs []string
+	client paho.Client
+
+	// latest is the time of the last location published per key, older
+	// locations (fetched late) don't override the retained one
+	mu     sync.Mutex
+	latest map[string]time.Time
+}
+
+// New returns a Publisher of the locations of keyIDs, call Connect to
+// connect to the broker
+func New(cfg Config, st store.Store, keyIDs []string) (*Publisher, error) {
+	if cfg.Broker == "" {
+		return nil, errors.New("mqtt broker is required")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = DefaultTopic
+	}
+	if !strings.Contains(cfg.Topic, KeyPlaceholder) {
+		return nil, fmt.Errorf("mqtt topic must contain %s", KeyPlaceholder)
+	}
+	if cfg.StatusTopic == "" {
+		cfg.StatusTopic = DefaultStatusTopic
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "searchparty-go"
+	}
+	p := &Publisher{cfg: cfg, st: st, keyIDs: keyIDs, latest: map[string]time.Time{}}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(cfg.StatusTopic, "offline", cfg.QoS, true).
+		SetOnConnectHandler(func(paho.Client) {
+			logger.Infof("connected to %s", cfg.Broker)
+			go p.announce(context.Background())
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logger.Warnf("connection to %s lost: %v", cfg.Broker, err)
+		})
+	p.client = paho.NewClient(opts)
+	return p, nil
+}
+
+// Connect connects to the broker, retrying in the background if it's not
+// reachable yet
+func (p *Publisher) Connect() error {
+	t := p.client.Connect()
+	if !t.WaitTimeout(publishTimeout) {
+		logger.Warnf("unable to connect to %s yet, retrying in the background", p.cfg.Broker)
+		return nil
+	}
+	return t.Error()
+}
+
+// Close publishes the offline status and disconnects
+func (p *Publisher) Close() {
+	if p.client.IsConnected() {
+		_ = p.publish(p.cfg.StatusTopic, true, []byte("offline"))
+	}
+	p.client.Disconnect(250) //nolint:mnd
+}
+
+// LocationSaved publishes location, errors are only logged
+func (p *Publisher) LocationSaved(_ context.Context, location *models.Location) {
+	if err := p.Publish(location); err != nil {
+		logger.Errorf("unable to publish location of %s: %v", location.KeyID, err)
+	}
+}
+
+// Publish publishes location unless a more recent one of its key was
+// already published
+func (p *Publisher) Publish(location *models.Location) error {
+	if location.Geometry == nil {
+		return nil
+	}
+	p.mu.Lock()
+	if !location.FoundAt.After(p.latest[location.KeyID]) {
+		p.mu.Unlock()
+		return nil
+	}
+	p.latest[location.KeyID] = location.FoundAt
+	p.mu.Unlock()
+
+	b, err := json.Marshal(newState(location))
+	if err != nil {
+		return err
+	}
+	return p.publish(p.Topic(location.KeyID), p.cfg.Retain, b)
+}
+
+func newState(l *models.Location) State {
+	return State{
+		Latitude:     l.Geometry.Coords().Y(),
+		Longitude:    l.Geometry.Coords().X(),
+		GPSAccuracy:  l.Confidence,
+		BatteryLevel: l.DecodedStatus().Battery().Percent(),
+		FoundAt:      l.FoundAt,
+		ReportedAt:   l.ReportedAt,
+		Status:       l.Status,
+		KeyID:        l.KeyID,
+	}
+}
+
+// Topic returns the topic of the locations of keyID
+func (p *Publisher) Topic(keyID string) string {
+	return strings.ReplaceAll(p.cfg.Topic, KeyPlaceholder, topicKeyID(keyID))
+}
+
+// topicKeyID turns a base64 key id into a valid topic level: + is a
+// wildcard and / a level separator
+func topicKeyID(keyID string) string {
+	return strings.NewReplacer("+", "-", "/", "_", "=", "").Replace(keyID)
+}
+
+func (p *Publisher) publish(topic string, retain bool, payload []byte) error {
+	// Doesn't block the fetches while the broker is unreachable
+	if !p.client.IsConnectionOpen() {
+		return errors.New("not connected")
+	}
+	t := p.client.Publish(topic, p.cfg.QoS, retain, payload)
+	if !t.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timeout publishing to %s", topic)
+	}
+	return t.Error()
+}
+
+// announce publishes the online status and the discovery configs
+func (p *Publisher) announce(ctx context.Context) {
+	if err := p.publish(p.cfg.StatusTopic, true, []byte("online")); err != nil {
+		logger.Errorf("unable to publish status: %v", err)
+	}
+	if p.cfg.DiscoveryPrefix == "" {
+		return
+	}
+	aliases := map[string]string{}
+	keyAliases, err := p.st.KeyAliases(ctx, p.keyIDs)
+	if err != nil {
+		logger.Warnf("unable to get key aliases: %v", err)
+	}
+	for _, a := range keyAliases {
+		aliases[a.KeyID] = a.Alias
+	}
+	for _, keyID := range p.keyIDs {
+		for topic, config := range p.discoveryConfigs(keyID, aliases[keyID]) {
+			b, err := json.Marshal(config)
+			if err != nil {
+				logger.Errorf("unable to encode discovery config: %v", err)
+				continue
+			}
+			if err := p.publish(topic, true, b); err != nil {
+				logger.Errorf("unable to publish discovery config of %s: %v", keyID, err)
+			}
+		}
+	}
+}
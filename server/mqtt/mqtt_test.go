@@ -0,0 +1,137 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/twpayne/go-geom"
+	"gorm.io/gorm"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// startBroker starts an embedded broker and returns its address and the
+// messages it receives by topic
+func startBroker(t *testing.T) (string, func(topic string) []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to find a free port: %v", err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	broker := mochi.New(&mochi.Options{InlineClient: true})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("unable to add hook: %v", err)
+	}
+	if err := broker.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})); err != nil {
+		t.Fatalf("unable to add listener: %v", err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatalf("unable to start broker: %v", err)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+
+	var mu sync.Mutex
+	messages := map[string][]byte{}
+	err = broker.Subscribe("#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		mu.Lock()
+		defer mu.Unlock()
+		messages[pk.TopicName] = pk.Payload
+	})
+	if err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+	return "tcp://" + addr, func(topic string) []byte {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			b, ok := messages[topic]
+			mu.Unlock()
+			if ok {
+				return b
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("no message on %s", topic)
+		return nil
+	}
+}
+
+func newLocation(t *testing.T, keyID string, foundAt time.Time, lat float64, lng float64) *models.Location {
+	t.Helper()
+	p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{lng, lat})
+	if err != nil {
+		t.Fatalf("unable to create point: %v", err)
+	}
+	g := models.GeomPoint(*p)
+	return &models.Location{FoundAt: foundAt, KeyID: keyID, Geometry: &g, Confidence: 12, Status: 0b01000000}
+}
+
+func TestPublisher(t *testing.T) {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "searchparty.db")
+	st, err := store.Open(ctx, "sqlite://"+dsn, true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+	db, err := gorm.Open(sqlite.Open(dsn))
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+	if err := db.Create(&models.KeyAlias{KeyID: "ab+c/d=", Alias: "Keys"}).Error; err != nil {
+		t.Fatalf("unable to create alias: %v", err)
+	}
+
+	broker, message := startBroker(t)
+	p, err := New(Config{Broker: broker, DiscoveryPrefix: DefaultDiscoveryPrefix, Retain: true}, st, []string{"ab+c/d="})
+	if err != nil {
+		t.Fatalf("unable to create publisher: %v", err)
+	}
+	if err := p.Connect(); err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	defer p.Close()
+
+	if status := string(message(DefaultStatusTopic)); status != "online" {
+		t.Fatalf("expected online status, got %q", status)
+	}
+	var tracker discoveryConfig
+	if err := json.Unmarshal(message("homeassistant/device_tracker/searchparty_ab-c_d/config"), &tracker); err != nil {
+		t.Fatalf("invalid discovery config: %v", err)
+	}
+	if tracker.Device.Name != "Keys" || tracker.JSONAttributesTopic != "searchparty/ab-c_d/location" || tracker.SourceType != "gps" {
+		t.Fatalf("unexpected discovery config %+v", tracker)
+	}
+	message("homeassistant/sensor/searchparty_ab-c_d_battery/config")
+
+	now := time.Now().Truncate(time.Second)
+	if err := p.Publish(newLocation(t, "ab+c/d=", now, 47.37, 8.54)); err != nil {
+		t.Fatalf("unable to publish: %v", err)
+	}
+	// Older locations don't replace the published one
+	if err := p.Publish(newLocation(t, "ab+c/d=", now.Add(-time.Hour), 46, 7)); err != nil {
+		t.Fatalf("unable to publish: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	var state State
+	if err := json.Unmarshal(message("searchparty/ab-c_d/location"), &state); err != nil {
+		t.Fatalf("invalid state: %v", err)
+	}
+	if state.Latitude != 47.37 || state.Longitude != 8.54 || state.GPSAccuracy != 12 || state.BatteryLevel != 50 {
+		t.Fatalf("unexpected state %+v", state)
+	}
+}
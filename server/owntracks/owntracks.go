@@ -0,0 +1,123 @@
+// Package owntracks converts the locations to OwnTracks messages, pushed to
+// an OwnTracks Recorder or written in its .rec format
+package owntracks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+// Message is an OwnTracks location message
+type Message struct {
+	Type string `json:"_type"`
+	// TID is the tracker id shown on the maps, two characters
+	TID string  `json:"tid"`
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	// Acc is the accuracy in meters
+	Acc int `json:"acc"`
+	// Tst is the time of the location, in seconds since the epoch
+	Tst int64 `json:"tst"`
+	// Trigger "p" marks a location that wasn't requested by the user
+	Trigger string `json:"t"`
+}
+
+// NewMessage returns the message of l, a location of the key named alias
+func NewMessage(l *models.Location, alias string) Message {
+	return Message{
+		Type:    "location",
+		TID:     TID(alias, l.KeyID),
+		Lat:     l.Geometry.Coords().Y(),
+		Lon:     l.Geometry.Coords().X(),
+		Acc:     l.Confidence,
+		Tst:     l.FoundAt.Unix(),
+		Trigger: "p",
+	}
+}
+
+// TID derives the tracker id of a key from its alias: the initials of its
+// first two words, or its first two letters. The key id is used if the
+// alias has no letters.
+func TID(alias string, keyID string) string {
+	var tid []rune
+	words := strings.FieldsFunc(alias, func(r rune) bool {
+		return !isAlphanumeric(r)
+	})
+	switch {
+	case len(words) >= 2:
+		tid = []rune{[]rune(words[0])[0], []rune(words[1])[0]}
+	case len(words) == 1:
+		tid = []rune(words[0])
+	default:
+		tid = []rune(strings.Map(func(r rune) rune {
+			if isAlphanumeric(r) {
+				return r
+			}
+			return -1
+		}, keyID))
+	}
+	if len(tid) > 2 {
+		tid = tid[:2]
+	}
+	return strings.ToUpper(string(tid))
+}
+
+// Device returns the OwnTracks device name of a key, its alias lowercased
+// without spaces, or its key id if the alias has no letters or digits
+func Device(alias string, keyID string) string {
+	if name := deviceName(alias); strings.IndexFunc(name, isAlphanumeric) >= 0 {
+		return name
+	}
+	return deviceName(keyID)
+}
+
+func deviceName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case isAlphanumeric(r):
+			return unicode.ToLower(r)
+		case r == '-' || r == '_':
+			return r
+		case unicode.IsSpace(r), r == '/', r == '+':
+			return '-'
+		default:
+			return -1
+		}
+	}, name)
+}
+
+func isAlphanumeric(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// recTopic is the topic column of the messages received over HTTP
+const recTopic = "*"
+
+// WriteRec writes messages in the Recorder's .rec format: one line per
+// message with its UTC time, the topic and the JSON message
+func WriteRec(w io.Writer, messages []Message) error {
+	for _, m := range messages {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		t := time.Unix(m.Tst, 0).UTC().Format("2006-01-02T15:04:05Z")
+		if _, err := fmt.Fprintf(w, "%s\t%-18s\t%s\n", t, recTopic, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecPath returns the path of the .rec file of user's device for the month
+// of t, relative to the Recorder's storage directory
+func RecPath(user string, device string, t time.Time) string {
+	return filepath.Join("rec", user, device, t.UTC().Format("2006-01")+".rec")
+}
@@ -0,0 +1,198 @@
+package owntracks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func newLocation(t *testing.T, keyID string, foundAt time.Time) *models.Location {
+	t.Helper()
+	p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{8.54, 47.37})
+	if err != nil {
+		t.Fatalf("unable to create point: %v", err)
+	}
+	g := models.GeomPoint(*p)
+	return &models.Location{FoundAt: foundAt, KeyID: keyID, Geometry: &g, Confidence: 15}
+}
+
+func TestTID(t *testing.T) {
+	tests := []struct {
+		alias, keyID, want string
+	}{
+		{"Car Keys", "abc", "CK"},
+		{"backpack", "abc", "BA"},
+		{"", "+a/bc=", "AB"},
+		{"🔑", "x", "X"},
+	}
+	for _, tt := range tests {
+		if got := TID(tt.alias, tt.keyID); got != tt.want {
+			t.Fatalf("TID(%q, %q): expected %q, got %q", tt.alias, tt.keyID, tt.want, got)
+		}
+	}
+	if got := Device("Car Keys!", "abc"); got != "car-keys" {
+		t.Fatalf("unexpected device %q", got)
+	}
+	for _, alias := range []string{"", "  ", "🔑"} {
+		if got := Device(alias, "a+b/c="); got != "a-b-c" {
+			t.Fatalf("unexpected device %q for alias %q", got, alias)
+		}
+	}
+}
+
+func TestWriteRec(t *testing.T) {
+	foundAt := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	var b bytes.Buffer
+	if err := WriteRec(&b, []Message{NewMessage(newLocation(t, "key", foundAt), "Car Keys")}); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+	want := "2024-03-01T12:30:00Z\t*                 \t" +
+		`{"_type":"location","tid":"CK","lat":47.37,"lon":8.54,"acc":15,"tst":1709296200,"t":"p"}` + "\n"
+	if b.String() != want {
+		t.Fatalf("expected %q, got %q", want, b.String())
+	}
+	if p := RecPath("user", "car-keys", foundAt); p != filepath.Join("rec", "user", "car-keys", "2024-03.rec") {
+		t.Fatalf("unexpected path %s", p)
+	}
+}
+
+func TestPusher(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	var got Message
+	var user, device string
+	recorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, device = r.Header.Get("X-Limit-U"), r.Header.Get("X-Limit-D")
+		b, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(b, &got)
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer recorder.Close()
+
+	p, err := NewPusher(Config{URL: recorder.URL, User: "me"}, st)
+	if err != nil {
+		t.Fatalf("unable to create pusher: %v", err)
+	}
+	foundAt := time.Now().Truncate(time.Second)
+	if err := p.Push(ctx, newLocation(t, "a/b", foundAt)); err != nil {
+		t.Fatalf("unable to push: %v", err)
+	}
+	if user != "me" || device != "a-b" || got.Type != "location" || got.Tst != foundAt.Unix() || got.Acc != 15 {
+		t.Fatalf("unexpected message %+v for %s/%s", got, user, device)
+	}
+}
+
+func TestExportRec(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	for _, foundAt := range []time.Time{
+		time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 4, 1, 1, 0, 0, 0, time.UTC),
+	} {
+		if _, err := st.SaveLocation(ctx, newLocation(t, "key", foundAt)); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	n, err := ExportRec(ctx, st, dir, "me", []string{"key"}, time.Time{}, time.Now())
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 locations exported, got %d %v", n, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "rec", "me", "key", "2024-03.rec"))
+	if err != nil {
+		t.Fatalf("unable to read export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "2024-03-01T00:00:00Z") {
+		t.Fatalf("unexpected export %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rec", "me", "key", "2024-04.rec")); err != nil {
+		t.Fatalf("expected an April file: %v", err)
+	}
+}
+
+func TestExportRecMerge(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	// The Recorder already has a location pushed on March 10 and one of the
+	// locations exported
+	dir := t.TempDir()
+	path := filepath.Join(dir, RecPath("me", "key", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("unable to create dir: %v", err)
+	}
+	var existing bytes.Buffer
+	for _, foundAt := range []time.Time{
+		time.Date(2024, 3, 10, 8, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 20, 8, 0, 0, 0, time.UTC),
+	} {
+		if err := WriteRec(&existing, []Message{NewMessage(newLocation(t, "key", foundAt), "")}); err != nil {
+			t.Fatalf("unable to write: %v", err)
+		}
+	}
+	if err := os.WriteFile(path, existing.Bytes(), 0o644); err != nil {
+		t.Fatalf("unable to write rec file: %v", err)
+	}
+
+	for _, foundAt := range []time.Time{
+		time.Date(2024, 3, 20, 8, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 16, 8, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 25, 8, 0, 0, 0, time.UTC),
+	} {
+		if _, err := st.SaveLocation(ctx, newLocation(t, "key", foundAt)); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+	}
+	from := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	for run, want := range []int{2, 0} {
+		n, err := ExportRec(ctx, st, dir, "me", []string{"key"}, from, time.Now())
+		if err != nil || n != want {
+			t.Fatalf("run %d: expected %d locations exported, got %d %v", run, want, n, err)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var days []string
+	for _, line := range lines {
+		days = append(days, line[:10])
+	}
+	want := []string{"2024-03-10", "2024-03-16", "2024-03-20", "2024-03-25"}
+	if !slices.Equal(days, want) {
+		t.Fatalf("expected lines of %v, got %q", want, b)
+	}
+}
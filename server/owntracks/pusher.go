@@ -0,0 +1,126 @@
+package owntracks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "owntracks")
+
+const (
+	// queueSize is the number of locations waiting to be pushed, the new
+	// locations are dropped when it's full
+	queueSize = 1000
+	// requestTimeout bounds a push
+	requestTimeout = 10 * time.Second
+)
+
+// Config describes the Recorder endpoint
+type Config struct {
+	// URL is the Recorder's HTTP endpoint, e.g. http://localhost:8083/pub
+	URL string
+	// User is the OwnTracks user the keys belong to
+	User     string
+	Username string
+	Password string
+}
+
+// Pusher pushes the new locations to an OwnTracks Recorder. It implements
+// store.LocationHook.
+type Pusher struct {
+	cfg    Config
+	st     store.Store
+	client *http.Client
+	queue  chan models.Location
+}
+
+// NewPusher returns a Pusher, Run must be called to push the locations
+func NewPusher(cfg Config, st store.Store) (*Pusher, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("owntracks url is required")
+	}
+	if cfg.User == "" {
+		return nil, errors.New("owntracks user is required")
+	}
+	return &Pusher{
+		cfg:    cfg,
+		st:     st,
+		client: &http.Client{Timeout: requestTimeout},
+		queue:  make(chan models.Location, queueSize),
+	}, nil
+}
+
+// LocationSaved queues location, the fetches aren't slowed down by the Recorder
+func (p *Pusher) LocationSaved(_ context.Context, location *models.Location) {
+	if location.Geometry == nil {
+		return
+	}
+	select {
+	case p.queue <- *location:
+	default:
+		logger.Warnf("queue full, dropping location of %s", location.KeyID)
+	}
+}
+
+// Run pushes the queued locations until ctx is done
+func (p *Pusher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case l := <-p.queue:
+			if err := p.Push(ctx, &l); err != nil {
+				logger.Errorf("unable to push location of %s: %v", l.KeyID, err)
+			}
+		}
+	}
+}
+
+// Push sends location to the Recorder as a message of the key's device
+func (p *Pusher) Push(ctx context.Context, location *models.Location) error {
+	alias := ""
+	aliases, err := p.st.KeyAliases(ctx, []string{location.KeyID})
+	if err != nil {
+		logger.Warnf("unable to get alias of %s: %v", location.KeyID, err)
+	}
+	if len(aliases) > 0 {
+		alias = aliases[0].Alias
+	}
+	b, err := json.Marshal(NewMessage(location, alias))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// The Recorder stores the message under rec/<user>/<device>
+	req.Header.Set("X-Limit-U", p.cfg.User)
+	req.Header.Set("X-Limit-D", Device(alias, location.KeyID))
+	if p.cfg.Username != "" {
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package owntracks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// ExportRec writes the locations of keyIDs found between from and to in
+// the Recorder's storage layout under dir, one .rec file per key and month.
+// Existing files are merged: their lines are kept and the locations whose
+// time is already recorded are skipped. It returns the number of locations
+// written.
+func ExportRec(ctx context.Context, st store.Store, dir string, user string, keyIDs []string, from time.Time, to time.Time) (int, error) {
+	aliases := map[string]string{}
+	keyAliases, err := st.KeyAliases(ctx, keyIDs)
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range keyAliases {
+		aliases[a.KeyID] = a.Alias
+	}
+
+	written := 0
+	for _, keyID := range keyIDs {
+		locations, err := st.Locations(ctx, keyID, from, to)
+		if err != nil {
+			return written, err
+		}
+		// Oldest first, as the Recorder appends them
+		slices.Reverse(locations)
+		alias := aliases[keyID]
+		device := Device(alias, keyID)
+
+		byFile := map[string][]Message{}
+		var paths []string
+		for i := range locations {
+			l := &locations[i]
+			if l.Geometry == nil {
+				continue
+			}
+			p := filepath.Join(dir, RecPath(user, device, l.FoundAt))
+			if _, ok := byFile[p]; !ok {
+				paths = append(paths, p)
+			}
+			byFile[p] = append(byFile[p], NewMessage(l, alias))
+		}
+		for _, p := range paths {
+			n, err := mergeRecFile(p, byFile[p])
+			if err != nil {
+				return written, fmt.Errorf("unable to write %s: %w", p, err)
+			}
+			written += n
+		}
+	}
+	return written, nil
+}
+
+// mergeRecFile adds messages to the .rec file at path, skipping the ones
+// whose tst is already recorded. The lines are kept sorted by time and the
+// file is replaced atomically, so that the Recorder never reads a partial
+// file. It returns the number of messages added.
+func mergeRecFile(path string, messages []Message) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:mnd
+		return 0, err
+	}
+	lines, err := readRecLines(path)
+	if err != nil {
+		return 0, err
+	}
+	recorded := map[int64]bool{}
+	for _, line := range lines {
+		if tst, ok := recLineTst(line); ok {
+			recorded[tst] = true
+		}
+	}
+	added := 0
+	for _, m := range messages {
+		if recorded[m.Tst] {
+			continue
+		}
+		var b bytes.Buffer
+		if err := WriteRec(&b, []Message{m}); err != nil {
+			return 0, err
+		}
+		lines = append(lines, strings.TrimSuffix(b.String(), "\n"))
+		recorded[m.Tst] = true
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	// The first column is the UTC time, which sorts lexicographically
+	slices.SortStableFunc(lines, func(a, b string) int {
+		ta, _, _ := strings.Cut(a, "\t")
+		tb, _, _ := strings.Cut(b, "\t")
+		return strings.Compare(ta, tb)
+	})
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		_, _ = w.WriteString(line)
+		_ = w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil { //nolint:mnd
+		return 0, err
+	}
+	return added, os.Rename(f.Name(), path)
+}
+
+// readRecLines returns the non empty lines of the .rec file at path, none if
+// it doesn't exist
+func readRecLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20) //nolint:mnd
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// recLineTst returns the tst of the location message of a .rec line
+func recLineTst(line string) (int64, bool) {
+	parts := strings.SplitN(line, "\t", 3) //nolint:mnd
+	if len(parts) != 3 {
+		return 0, false
+	}
+	var m Message
+	if err := json.Unmarshal([]byte(parts[2]), &m); err != nil || m.Type != "location" {
+		return 0, false
+	}
+	return m.Tst, true
+}
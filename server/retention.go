@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// defaultDownsampleBucket is the bucket used when RetentionPolicy.Bucket isn't set
+const defaultDownsampleBucket = 1 * time.Hour
+
+// RetentionPolicy describes which locations are kept
+type RetentionPolicy struct {
+	// RawFor is how long every location is kept, older locations are
+	// downsampled to one per key and Bucket. 0 disables downsampling.
+	RawFor time.Duration
+	// Bucket is the downsampling interval, the location with the highest
+	// confidence of each bucket is kept
+	Bucket time.Duration
+	// DeleteAfter is how long the locations are kept at all, 0 keeps them forever
+	DeleteAfter time.Duration
+	// DropOriginalContent removes the encrypted payload of the stored
+	// locations, which are only needed to decode them again
+	DropOriginalContent bool
+	// DryRun only reports what would be removed
+	DryRun bool
+}
+
+// Enabled returns whether the policy removes anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.RawFor > 0 || p.DeleteAfter > 0 || p.DropOriginalContent
+}
+
+// RetentionReport is the result of a retention run
+type RetentionReport struct {
+	StartedAt   time.Time `json:"startedAt"`
+	DryRun      bool      `json:"dryRun"`
+	Downsampled int64     `json:"downsampled"`
+	Deleted     int64     `json:"deleted"`
+	Cleared     int64     `json:"cleared"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Retention applies a RetentionPolicy to the stored locations
+type Retention struct {
+	st     store.Store
+	policy RetentionPolicy
+
+	mu   sync.Mutex
+	last *RetentionReport
+}
+
+// NewRetention returns a Retention applying policy to the locations of s.
+// Its last report is served by s under /api/v1/retention.
+func NewRetention(s *Server, policy RetentionPolicy) *Retention {
+	if policy.Bucket <= 0 {
+		policy.Bucket = defaultDownsampleBucket
+	}
+	r := &Retention{st: s.store, policy: policy}
+	s.retention = r
+	return r
+}
+
+// Run applies the policy every interval until ctx is done
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := r.Apply(ctx, r.policy.DryRun)
+		if err != nil {
+			logger.Errorf("retention failed: %v", err)
+		} else {
+			verb := "removed"
+			if report.DryRun {
+				verb = "would remove"
+			}
+			logger.Infof("retention %s %d downsampled and %d expired locations, cleared %d payloads",
+				verb, report.Downsampled, report.Deleted, report.Cleared)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Apply applies the policy once. If dryRun is set nothing is removed, the
+// report contains what would be.
+func (r *Retention) Apply(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	report := &RetentionReport{StartedAt: time.Now(), DryRun: dryRun}
+	err := r.apply(ctx, report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if !dryRun || r.policy.DryRun {
+		r.mu.Lock()
+		r.last = report
+		r.mu.Unlock()
+	}
+	return report, err
+}
+
+func (r *Retention) apply(ctx context.Context, report *RetentionReport) error {
+	now := report.StartedAt
+	var deleteBefore time.Time
+	if r.policy.DeleteAfter > 0 {
+		deleteBefore = now.Add(-r.policy.DeleteAfter)
+		n, err := r.st.DeleteLocationsBefore(ctx, deleteBefore, report.DryRun)
+		if err != nil {
+			return err
+		}
+		report.Deleted = n
+	}
+
+	if r.policy.RawFor > 0 {
+		keyIDs, err := r.st.LocationKeyIDs(ctx)
+		if err != nil {
+			return err
+		}
+		for _, keyID := range keyIDs {
+			samples, err := r.st.LocationSamples(ctx, keyID, deleteBefore, now.Add(-r.policy.RawFor))
+			if err != nil {
+				return err
+			}
+			remove := downsample(samples, r.policy.Bucket)
+			if len(remove) == 0 {
+				continue
+			}
+			if report.DryRun {
+				report.Downsampled += int64(len(remove))
+				continue
+			}
+			n, err := r.st.DeleteLocations(ctx, keyID, remove)
+			report.Downsampled += n
+			if err != nil {
+				return fmt.Errorf("unable to downsample %s: %w", keyID, err)
+			}
+		}
+	}
+
+	if r.policy.DropOriginalContent {
+		n, err := r.st.ClearOriginalContent(ctx, now, report.DryRun)
+		if err != nil {
+			return err
+		}
+		report.Cleared = n
+	}
+	return nil
+}
+
+// downsample returns the locations to remove to keep a single location per
+// bucket: the one with the highest confidence, the most recent one on ties.
+// samples must be sorted oldest first.
+func downsample(samples []store.LocationSample, bucket time.Duration) []time.Time {
+	var remove []time.Time
+	for start := 0; start < len(samples); {
+		b := samples[start].FoundAt.Truncate(bucket)
+		end := start + 1
+		for end < len(samples) && samples[end].FoundAt.Truncate(bucket).Equal(b) {
+			end++
+		}
+		best := start
+		for i := start + 1; i < end; i++ {
+			if samples[i].Confidence >= samples[best].Confidence {
+				best = i
+			}
+		}
+		for i := start; i < end; i++ {
+			if i != best {
+				remove = append(remove, samples[i].FoundAt)
+			}
+		}
+		start = end
+	}
+	return remove
+}
+
+// Last returns the report of the last run
+func (r *Retention) Last() *RetentionReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+func (s *Server) getRetention(c *gin.Context) {
+	if s.retention == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "retention not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"policy": gin.H{
+			"rawFor":              s.retention.policy.RawFor.String(),
+			"bucket":              s.retention.policy.Bucket.String(),
+			"deleteAfter":         s.retention.policy.DeleteAfter.String(),
+			"dropOriginalContent": s.retention.policy.DropOriginalContent,
+			"dryRun":              s.retention.policy.DryRun,
+		},
+		"last": s.retention.Last(),
+	})
+}
+
+// retentionDryRun reports what the retention policy would remove now
+func (s *Server) retentionDryRun(c *gin.Context) {
+	if s.retention == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "retention not enabled"})
+		return
+	}
+	report, err := s.retention.Apply(c.Request.Context(), true)
+	if err != nil {
+		logger.Errorf("retention dry run failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "retention dry run failed"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func TestDownsample(t *testing.T) {
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	samples := []store.LocationSample{
+		{FoundAt: base.Add(5 * time.Minute), Confidence: 1},
+		{FoundAt: base.Add(20 * time.Minute), Confidence: 3},
+		{FoundAt: base.Add(40 * time.Minute), Confidence: 3},
+		{FoundAt: base.Add(70 * time.Minute), Confidence: 2},
+		{FoundAt: base.Add(3 * time.Hour), Confidence: 1},
+		{FoundAt: base.Add(3*time.Hour + time.Minute), Confidence: 2},
+	}
+	remove := downsample(samples, time.Hour)
+	want := []time.Time{
+		base.Add(5 * time.Minute),
+		base.Add(20 * time.Minute),
+		base.Add(3 * time.Hour),
+	}
+	if len(remove) != len(want) {
+		t.Fatalf("expected %d locations to remove, got %v", len(want), remove)
+	}
+	for i := range want {
+		if !remove[i].Equal(want[i]) {
+			t.Fatalf("expected %s to be removed, got %s", want[i], remove[i])
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/model"
+	"github.com/denysvitali/searchparty-go/server/ingest"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+const (
+	// defaultLookback is how far back the scheduler looks for keys that were never fetched
+	defaultLookback = 24 * time.Hour
+	// maxLookback caps the window requested for keys that weren't fetched in a long time
+	maxLookback = 7 * 24 * time.Hour
+	// fetchOverlap is re-requested before the last fetched window, since the
+	// reports are often published some time after the location was found
+	fetchOverlap = 1 * time.Hour
+	// movementThreshold is the distance (in meters) between two locations
+	// above which a beacon is considered to be moving
+	movementThreshold = 250.0
+	// minWakeup is the shortest time the scheduler sleeps between two runs
+	minWakeup = 10 * time.Second
+)
+
+// KeyPollStatus is the polling status of a key
+type KeyPollStatus struct {
+	KeyID       string     `json:"keyId"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	// Reports is the number of reports returned by the last successful poll
+	Reports int `json:"reports"`
+	// Interval is the current polling interval of the key
+	Interval string     `json:"interval"`
+	NextPoll *time.Time `json:"nextPoll,omitempty"`
+	// Active is set when the key was lost or moving during the last poll
+	Active bool `json:"active"`
+
+	interval time.Duration
+}
+
+// SchedulerStatus is the status of the Scheduler, returned by /api/v1/scheduler
+type SchedulerStatus struct {
+	MinInterval string          `json:"minInterval"`
+	MaxInterval string          `json:"maxInterval"`
+	Running     bool            `json:"running"`
+	LastRun     *time.Time      `json:"lastRun,omitempty"`
+	NextRun     *time.Time      `json:"nextRun,omitempty"`
+	Keys        []KeyPollStatus `json:"keys"`
+}
+
+// Scheduler periodically fetches the reports of all the keys of a Server,
+// storing them like a refresh would. Each key is polled at its own interval:
+// keys that are lost or moving are polled every minInterval, the others back
+// off exponentially up to maxInterval.
+type Scheduler struct {
+	s           *Server
+	minInterval time.Duration
+	maxInterval time.Duration
+
+	mu      sync.Mutex
+	running bool
+	lastRun time.Time
+	nextRun time.Time
+	keys    map[string]*KeyPollStatus
+	wakeup  chan struct{}
+}
+
+// NewScheduler returns a Scheduler polling the keys of s every minInterval to
+// maxInterval, bounds that can be overridden per key.
+// Its status is served by s under /api/v1/scheduler.
+func NewScheduler(s *Server, minInterval time.Duration, maxInterval time.Duration) *Scheduler {
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	sc := &Scheduler{
+		s:           s,
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		keys:        map[string]*KeyPollStatus{},
+		wakeup:      make(chan struct{}, 1),
+	}
+	s.scheduler = sc
+	return sc
+}
+
+// Run polls the keys until ctx is done
+func (sc *Scheduler) Run(ctx context.Context) {
+	if err := sc.loadLastFetched(ctx); err != nil {
+		logger.Warnf("unable to load the last fetched windows: %v", err)
+	}
+	for {
+		sc.Poll(ctx)
+		wait := time.Until(sc.nextWakeup())
+		if wait < minWakeup {
+			wait = minWakeup
+		}
+		sc.mu.Lock()
+		sc.nextRun = time.Now().Add(wait)
+		sc.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		case <-sc.wakeup:
+		}
+	}
+}
+
+// nextWakeup returns when the next key is due
+func (sc *Scheduler) nextWakeup() time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	next := time.Now().Add(sc.maxInterval)
+	for id := range sc.s.keyMap {
+		st, ok := sc.keys[id]
+		if !ok || st.NextPoll == nil {
+			return time.Now()
+		}
+		if st.NextPoll.Before(next) {
+			next = *st.NextPoll
+		}
+	}
+	return next
+}
+
+// Poll fetches the new reports of the keys that are due. Keys sharing the
+// same window and lost time are fetched with a single request.
+func (sc *Scheduler) Poll(ctx context.Context) {
+	sc.mu.Lock()
+	if sc.running {
+		sc.mu.Unlock()
+		return
+	}
+	sc.running = true
+	sc.mu.Unlock()
+	defer func() {
+		sc.mu.Lock()
+		sc.running = false
+		sc.lastRun = time.Now()
+		sc.mu.Unlock()
+	}()
+
+	now := time.Now()
+	for _, b := range sc.batches(ctx, now) {
+		if ctx.Err() != nil {
+			return
+		}
+		sc.fetch(ctx, b, now)
+	}
+}
+
+type pollBatch struct {
+	from   time.Time
+	lostAt time.Time
+	// lost is set for the lost keys, even if they were lost before from
+	lost bool
+	keys []model.MainKey
+}
+
+// batches groups the keys by the window they have to be fetched from
+func (sc *Scheduler) batches(ctx context.Context, now time.Time) []pollBatch {
+	ids := make([]string, 0, len(sc.s.keyMap))
+	for id := range sc.s.keyMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	type batchKey struct {
+		from   time.Time
+		lostAt time.Time
+		lost   bool
+	}
+	index := map[batchKey]int{}
+	var batches []pollBatch
+	for _, id := range ids {
+		if !sc.due(id, now) {
+			continue
+		}
+		key := sc.s.keyMap[id]
+		bk := batchKey{from: sc.from(id, now).Truncate(time.Minute)}
+		// The lost time only selects the key schedule: the keys used before
+		// the polled window are never requested, however long ago the key
+		// was lost
+		lostAt := ingest.LostAt(ctx, sc.s.store, id)
+		bk.lost = !lostAt.IsZero()
+		if lostAt.After(bk.from) {
+			bk.lostAt = lostAt
+		}
+		i, ok := index[bk]
+		if !ok {
+			i = len(batches)
+			index[bk] = i
+			batches = append(batches, pollBatch{from: bk.from, lostAt: bk.lostAt, lost: bk.lost})
+		}
+		batches[i].keys = append(batches[i].keys, key)
+	}
+	return batches
+}
+
+// due returns whether keyID has to be polled
+func (sc *Scheduler) due(keyID string, now time.Time) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st, ok := sc.keys[keyID]
+	return !ok || st.NextPoll == nil || !now.Before(*st.NextPoll)
+}
+
+// from returns the start of the window to fetch for keyID
+func (sc *Scheduler) from(keyID string, now time.Time) time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	from := now.Add(-defaultLookback)
+	if st, ok := sc.keys[keyID]; ok && st.LastSuccess != nil {
+		from = st.LastSuccess.Add(-fetchOverlap)
+	}
+	if earliest := now.Add(-maxLookback); from.Before(earliest) {
+		from = earliest
+	}
+	return from
+}
+
+func (sc *Scheduler) fetch(ctx context.Context, b pollBatch, now time.Time) {
+	logger.Debugf("polling %d keys since %s", len(b.keys), b.from)
+	reports, subKeysMap, err := sc.s.c.FindRange(ctx, b.keys, searchparty.FindOptions{
+		From:   b.from,
+		To:     now,
+		LostAt: b.lostAt,
+	})
+	if err != nil {
+		if errors.Is(err, searchparty.ErrRateLimited) {
+			logger.Warnf("rate limited while polling %d keys: %v", len(b.keys), err)
+		} else {
+			logger.Errorf("unable to poll %d keys: %v", len(b.keys), err)
+		}
+		for _, k := range b.keys {
+			sc.update(ctx, k.ID(), now, err, 0, false)
+		}
+		return
+	}
+	ingest.Reports(ctx, sc.s.store, reports, subKeysMap)
+
+	counts := map[string]int{}
+	for _, r := range reports {
+		if k, ok := subKeysMap[r.ID]; ok {
+			counts[k.MainKey.ID()]++
+		}
+	}
+	for _, k := range b.keys {
+		active := b.lost
+		if !active && counts[k.ID()] > 0 {
+			moving, err := sc.s.isMoving(ctx, k.ID(), b.from)
+			if err != nil {
+				logger.Warnf("unable to check if %s is moving: %v", k.ID(), err)
+			}
+			active = moving
+		}
+		sc.update(ctx, k.ID(), now, nil, counts[k.ID()], active)
+		if err := sc.s.store.SetLastFetchedAt(ctx, k.ID(), now); err != nil {
+			logger.Warnf("unable to save the last fetched window of %s: %v", k.ID(), err)
+		}
+	}
+}
+
+// update records the result of a poll of keyID and schedules the next one.
+// On error the key is retried after its current interval.
+func (sc *Scheduler) update(ctx context.Context, keyID string, at time.Time, err error, reports int, active bool) {
+	minInterval, maxInterval := sc.bounds(ctx, keyID)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st := sc.status(keyID)
+	attempt := at
+	st.LastAttempt = &attempt
+	if err != nil {
+		st.LastError = err.Error()
+		st.interval = clampInterval(st.interval, minInterval, maxInterval)
+	} else {
+		success := at
+		st.LastSuccess = &success
+		st.LastError = ""
+		st.Reports = reports
+		st.Active = active
+		st.interval = nextInterval(st.interval, minInterval, maxInterval, active)
+	}
+	nextPoll := at.Add(st.interval)
+	st.NextPoll = &nextPoll
+	st.Interval = st.interval.String()
+}
+
+// bounds returns the polling bounds of keyID, taking its overrides into account
+func (sc *Scheduler) bounds(ctx context.Context, keyID string) (time.Duration, time.Duration) {
+	minInterval, maxInterval := sc.minInterval, sc.maxInterval
+	info, err := sc.s.store.KeyInfo(ctx, keyID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			logger.Warnf("unable to fetch key info: %v", err)
+		}
+		return minInterval, maxInterval
+	}
+	if info.MinPollSeconds != nil && *info.MinPollSeconds > 0 {
+		minInterval = time.Duration(*info.MinPollSeconds) * time.Second
+	}
+	if info.MaxPollSeconds != nil && *info.MaxPollSeconds > 0 {
+		maxInterval = time.Duration(*info.MaxPollSeconds) * time.Second
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	return minInterval, maxInterval
+}
+
+// nextInterval returns the interval after prev: keys that are active (lost or
+// moving) are polled every minInterval, the others back off exponentially
+func nextInterval(prev time.Duration, minInterval time.Duration, maxInterval time.Duration, active bool) time.Duration {
+	if active || prev <= 0 {
+		return minInterval
+	}
+	return clampInterval(2*prev, minInterval, maxInterval)
+}
+
+func clampInterval(d time.Duration, minInterval time.Duration, maxInterval time.Duration) time.Duration {
+	switch {
+	case d < minInterval:
+		return minInterval
+	case d > maxInterval:
+		return maxInterval
+	default:
+		return d
+	}
+}
+
+// status returns the status of keyID, sc.mu must be held
+func (sc *Scheduler) status(keyID string) *KeyPollStatus {
+	st, ok := sc.keys[keyID]
+	if !ok {
+		st = &KeyPollStatus{KeyID: keyID}
+		sc.keys[keyID] = st
+	}
+	return st
+}
+
+// loadLastFetched restores the last fetched windows stored by a previous run
+func (sc *Scheduler) loadLastFetched(ctx context.Context) error {
+	infos, err := sc.s.store.KeyInfos(ctx)
+	if err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, info := range infos {
+		if _, ok := sc.s.keyMap[info.ID]; !ok || info.LastFetchedAt == nil {
+			continue
+		}
+		sc.status(info.ID).LastSuccess = info.LastFetchedAt
+	}
+	return nil
+}
+
+// Status returns the status of the scheduler and of all the keys
+func (sc *Scheduler) Status() SchedulerStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	res := SchedulerStatus{
+		MinInterval: sc.minInterval.String(),
+		MaxInterval: sc.maxInterval.String(),
+		Running:     sc.running,
+		Keys:        make([]KeyPollStatus, 0, len(sc.s.keyMap)),
+	}
+	if !sc.lastRun.IsZero() {
+		lastRun := sc.lastRun
+		res.LastRun = &lastRun
+	}
+	if !sc.nextRun.IsZero() {
+		nextRun := sc.nextRun
+		res.NextRun = &nextRun
+	}
+	for id := range sc.s.keyMap {
+		st := KeyPollStatus{KeyID: id}
+		if s, ok := sc.keys[id]; ok {
+			st = *s
+		}
+		st.KeyID = cleanedKeyID(id)
+		res.Keys = append(res.Keys, st)
+	}
+	sort.Slice(res.Keys, func(i, j int) bool {
+		return res.Keys[i].KeyID < res.Keys[j].KeyID
+	})
+	return res
+}
+
+func (s *Server) getSchedulerStatus(c *gin.Context) {
+	if s.scheduler == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "scheduler not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, s.scheduler.Status())
+}
+
+type pollingRequest struct {
+	// MinInterval and MaxInterval are Go durations (e.g. "5m"), empty to use the scheduler defaults
+	MinInterval string `json:"minInterval"`
+	MaxInterval string `json:"maxInterval"`
+}
+
+// setPolling sets the polling bounds of a key
+func (s *Server) setPolling(c *gin.Context) {
+	keyID := dirtyKeyID(c.Param("keyId"))
+	if _, ok := s.keyMap[keyID]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	var req pollingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	minSeconds, err := parsePollSeconds(req.MinInterval)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid minInterval: %v", err)})
+		return
+	}
+	maxSeconds, err := parsePollSeconds(req.MaxInterval)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid maxInterval: %v", err)})
+		return
+	}
+	if minSeconds != nil && maxSeconds != nil && *maxSeconds < *minSeconds {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxInterval must not be shorter than minInterval"})
+		return
+	}
+
+	if err := s.store.SetPollingBounds(c.Request.Context(), keyID, minSeconds, maxSeconds); err != nil {
+		logger.Errorf("unable to save polling bounds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to save polling bounds"})
+		return
+	}
+	if s.scheduler != nil {
+		s.scheduler.reschedule(c.Request.Context(), keyID)
+	}
+	s.keyChanged(c.Request.Context(), keyID)
+	c.JSON(http.StatusOK, models.KeyInfo{ID: keyID, MinPollSeconds: minSeconds, MaxPollSeconds: maxSeconds})
+}
+
+func parsePollSeconds(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	if d < time.Second {
+		return nil, errors.New("must be at least 1s")
+	}
+	seconds := int(d / time.Second)
+	return &seconds, nil
+}
+
+// reschedule applies new polling bounds to the next poll of keyID
+func (sc *Scheduler) reschedule(ctx context.Context, keyID string) {
+	minInterval, maxInterval := sc.bounds(ctx, keyID)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st, ok := sc.keys[keyID]
+	if !ok || st.LastAttempt == nil {
+		return
+	}
+	st.interval = clampInterval(st.interval, minInterval, maxInterval)
+	nextPoll := st.LastAttempt.Add(st.interval)
+	st.NextPoll = &nextPoll
+	st.Interval = st.interval.String()
+	select {
+	case sc.wakeup <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/model"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func TestNextInterval(t *testing.T) {
+	minInterval, maxInterval := 15*time.Minute, 2*time.Hour
+	tests := []struct {
+		name   string
+		prev   time.Duration
+		active bool
+		want   time.Duration
+	}{
+		{"first poll", 0, false, minInterval},
+		{"backs off", minInterval, false, 30 * time.Minute},
+		{"capped", 90 * time.Minute, false, maxInterval},
+		{"active", maxInterval, true, minInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextInterval(tt.prev, minInterval, maxInterval, tt.active); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMoved(t *testing.T) {
+	loc := func(lat float64, lng float64) models.Location {
+		p := models.GeomPoint(*geom.NewPoint(geom.XY).MustSetCoords(geom.Coord{lng, lat}))
+		return models.Location{Geometry: &p}
+	}
+	stationary := []models.Location{loc(47.3779, 8.5403), loc(47.3780, 8.5404), loc(47.3778, 8.5402)}
+	if moved(stationary, movementThreshold) {
+		t.Fatalf("expected stationary locations not to have moved")
+	}
+	moving := append(stationary, loc(47.3850, 8.5403))
+	if !moved(moving, movementThreshold) {
+		t.Fatalf("expected locations to have moved")
+	}
+}
+
+// testKey is a key without subkeys
+type testKey string
+
+func (k testKey) ID() string { return string(k) }
+
+func (k testKey) GetSubKeys(time.Time, time.Time, time.Time) ([]model.SubKey, error) {
+	return nil, nil
+}
+
+func (k testKey) KeyInfo() model.KeyInfo { return model.KeyInfo{} }
+
+func (k testKey) Type() string { return "test" }
+
+func newTestServer(t *testing.T, keyIDs ...string) *Server {
+	t.Helper()
+	st, err := store.Open(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	keyMap := map[string]model.MainKey{}
+	for _, id := range keyIDs {
+		keyMap[id] = testKey(id)
+	}
+	return New(nil, st, keyMap)
+}
+
+func TestSchedulerBatchesLostAt(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, "found", "lost-long-ago", "lost-recently")
+	sc := NewScheduler(s, 15*time.Minute, 2*time.Hour)
+	now := time.Now()
+	lostLongAgo := now.Add(-30 * 24 * time.Hour)
+	lostRecently := now.Add(-time.Hour)
+	if err := s.store.SetLostAt(ctx, "lost-long-ago", &lostLongAgo); err != nil {
+		t.Fatalf("unable to mark key as lost: %v", err)
+	}
+	if err := s.store.SetLostAt(ctx, "lost-recently", &lostRecently); err != nil {
+		t.Fatalf("unable to mark key as lost: %v", err)
+	}
+
+	batches := sc.batches(ctx, now)
+	if len(batches) != 3 {
+		t.Fatalf("expected 3 batches, got %+v", batches)
+	}
+	for _, b := range batches {
+		if len(b.keys) != 1 {
+			t.Fatalf("unexpected batch %+v", b)
+		}
+		switch b.keys[0].ID() {
+		case "found":
+			if b.lost || !b.lostAt.IsZero() {
+				t.Errorf("unexpected batch of a found key %+v", b)
+			}
+		case "lost-long-ago":
+			// Lost before the window: the keys are derived from the window
+			if !b.lost || !b.lostAt.IsZero() {
+				t.Errorf("expected a lost key without lost time, got %+v", b)
+			}
+		case "lost-recently":
+			if !b.lost || !b.lostAt.Equal(lostRecently) {
+				t.Errorf("unexpected batch %+v", b)
+			}
+		}
+		if b.lostAt.IsZero() && b.from.Before(now.Add(-defaultLookback-time.Minute)) {
+			t.Errorf("window starting at %s goes back further than the default lookback", b.from)
+		}
+	}
+
+	// The lost keys are polled at the minimum interval, however long ago
+	// they were lost, the found one without reports backs off
+	s.c = noReports{}
+	for i := 0; i < 2; i++ {
+		at := now.Add(time.Duration(i) * 2 * time.Hour)
+		for _, b := range sc.batches(ctx, at) {
+			sc.fetch(ctx, b, at)
+		}
+	}
+	for id, want := range map[string]time.Duration{
+		"found":         30 * time.Minute,
+		"lost-long-ago": 15 * time.Minute,
+		"lost-recently": 15 * time.Minute,
+	} {
+		if st := sc.keys[id]; st.interval != want {
+			t.Errorf("expected %s to be polled every %s, got %s", id, want, st.interval)
+		}
+	}
+}
+
+// noReports is a searchparty.Finder without reports
+type noReports struct{}
+
+func (noReports) FindRange(context.Context, []model.MainKey, searchparty.FindOptions) ([]searchparty.Report, map[string]model.SubKey, error) {
+	return nil, map[string]model.SubKey{}, nil
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// searchLocations returns the locations found within a bounding box
+// (minLat, minLng, maxLat, maxLng) or within radius meters of a point
+// (lat, lng, radius) between from and to (RFC3339). The search can be
+// restricted to some keys with the (repeatable) key parameter.
+func (s *Server) searchLocations(c *gin.Context) {
+	q, err := s.parseAreaQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	locations, err := s.store.LocationsWithin(c.Request.Context(), *q)
+	if err != nil {
+		logger.Errorf("unable to search locations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to search locations"})
+		return
+	}
+	res := make([]models.LocationResult, 0, len(locations))
+	for _, l := range locations {
+		res = append(res, newLocationResult(l))
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) parseAreaQuery(c *gin.Context) (*store.AreaQuery, error) {
+	var q store.AreaQuery
+	var err error
+	if to := c.Query("to"); to != "" {
+		if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return nil, errors.New("to must be a RFC3339 timestamp")
+		}
+	}
+	if from := c.Query("from"); from != "" {
+		if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return nil, errors.New("from must be a RFC3339 timestamp")
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 1 {
+			return nil, fmt.Errorf("limit must be between 1 and %d", store.MaxSearchResults)
+		}
+	}
+	q.SetDefaults()
+
+	for _, k := range c.QueryArray("key") {
+		keyID := dirtyKeyID(k)
+		if _, ok := s.keyMap[keyID]; !ok {
+			return nil, fmt.Errorf("key %q not found", k)
+		}
+		q.KeyIDs = append(q.KeyIDs, keyID)
+	}
+
+	switch {
+	case c.Query("radius") != "":
+		values, err := queryFloats(c, "lat", "lng", "radius")
+		if err != nil {
+			return nil, err
+		}
+		q.Circle = &store.Circle{Lat: values[0], Lng: values[1], Radius: values[2]}
+	case c.Query("minLat") != "":
+		values, err := queryFloats(c, "minLat", "minLng", "maxLat", "maxLng")
+		if err != nil {
+			return nil, err
+		}
+		q.BoundingBox = &store.BoundingBox{MinLat: values[0], MinLng: values[1], MaxLat: values[2], MaxLng: values[3]}
+	}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// queryFloats parses the required float query parameters names
+func queryFloats(c *gin.Context, names ...string) ([]float64, error) {
+	values := make([]float64, 0, len(names))
+	for _, name := range names {
+		v, err := strconv.ParseFloat(c.Query(name), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", name)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
@@ -2,9 +2,7 @@ package server
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
-	"fmt"
 	"net/http"
 	"sort"
 	"strconv"
@@ -13,47 +11,54 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
-	"github.com/twpayne/go-geom"
 	"golang.org/x/exp/maps"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 
 	"github.com/denysvitali/searchparty-go"
 	"github.com/denysvitali/searchparty-go/model"
+	"github.com/denysvitali/searchparty-go/server/events"
+	"github.com/denysvitali/searchparty-go/server/ingest"
 	"github.com/denysvitali/searchparty-go/server/models"
 	"github.com/denysvitali/searchparty-go/server/responses"
+	"github.com/denysvitali/searchparty-go/server/store"
+	"github.com/denysvitali/searchparty-go/server/traccar"
+	"github.com/denysvitali/searchparty-go/server/webhook"
 )
 
 var logger = logrus.StandardLogger().WithField("pkg", "server")
 
 type Server struct {
-	dsn            string
-	db             *gorm.DB
-	e              *gin.Engine
-	c              *searchparty.Client
-	keyMap         map[string]model.MainKey
-	beaconStoreKey []byte
+	store  store.Store
+	e      *gin.Engine
+	c      searchparty.Finder
+	keyMap map[string]model.MainKey
+
+	scheduler *Scheduler
+	retention *Retention
+	webhooks  *webhook.Dispatcher
+	forwarder *traccar.Forwarder
+	events    *events.Broker
+
+	keyLostHandlers []KeyLostHandler
 }
 
-func New(auth *searchparty.Auth, anisetteURL string, dsn string, beaconStoreKey []byte) (*Server, error) {
+// New returns a Server fetching the reports with finder, either a
+// *searchparty.Client or a *searchparty.AccountPool, and storing the
+// locations in st
+func New(finder searchparty.Finder, st store.Store, keyMap map[string]model.MainKey) *Server {
 	s := Server{
-		dsn:            dsn,
-		e:              gin.New(),
-		c:              searchparty.New(auth, anisetteURL),
-		keyMap:         map[string]model.MainKey{},
-		beaconStoreKey: beaconStoreKey,
+		store:  st,
+		e:      gin.New(),
+		c:      finder,
+		keyMap: keyMap,
 	}
+	s.init()
+	return &s
+}
 
-	if err := s.loadKeys("./beacons/"); err != nil {
-		return nil, fmt.Errorf("failed to load keys: %w", err)
-	}
-	if err := s.init(); err != nil {
-		return nil, fmt.Errorf("unable to init server: %w", err)
-	}
-	return &s, nil
+// Handler returns the REST API handler, serving the routes under /api/v1
+func (s *Server) Handler() http.Handler {
+	return s.e
 }
 
 func (s *Server) Listen(addr ...string) error {
@@ -61,9 +66,8 @@ func (s *Server) Listen(addr ...string) error {
 	return s.e.Run(addr...)
 }
 
-func (s *Server) init() error {
-	var errArr []error
-	errArr = append(errArr, s.initDB())
+func (s *Server) init() {
+	s.e.Use(gin.Recovery())
 	s.e.Use(cors.New(cors.Config{
 		AllowAllOrigins: true,
 	}))
@@ -72,38 +76,38 @@ func (s *Server) init() error {
 	v1.GET("/keys/:keyId", s.getLastLocation)
 	v1.GET("/keys/:keyId/refresh", s.refreshLocation)
 	v1.GET("/keys/:keyId/history", s.getLocationHistory)
-	return errors.Join(errArr...)
-}
-
-func (s *Server) initDB() error {
-	db, err := gorm.Open(postgres.New(postgres.Config{
-		DSN:        s.dsn,
-		DriverName: "postgres",
-	}), &gorm.Config{})
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
-	}
-
-	m := []any{
-		&models.Location{},
-		&models.KeyAlias{},
-		&models.KeyInfo{},
-	}
-	for _, m := range m {
-		if err := db.AutoMigrate(m); err != nil {
-			return fmt.Errorf("failed to migrate model: %w", err)
-		}
-	}
-	s.db = db
-	return nil
+	v1.GET("/keys/:keyId/export", s.exportHistory)
+	v1.PUT("/keys/:keyId/polling", s.setPolling)
+	v1.PUT("/keys/:keyId/lost", s.setLost)
+	v1.DELETE("/keys/:keyId/lost", s.clearLost)
+	v1.PUT("/keys/:keyId/traccar", s.setTraccar)
+	v1.POST("/keys/:keyId/traccar/replay", s.replayTraccar)
+	v1.GET("/locations", s.searchLocations)
+	v1.GET("/stream", s.streamEvents)
+	v1.GET("/scheduler", s.getSchedulerStatus)
+	v1.GET("/retention", s.getRetention)
+	v1.POST("/retention/dry-run", s.retentionDryRun)
+	v1.GET("/geofences", s.getGeofences)
+	v1.POST("/geofences", s.saveGeofence)
+	v1.GET("/geofences/:id", s.getGeofence)
+	v1.PUT("/geofences/:id", s.saveGeofence)
+	v1.DELETE("/geofences/:id", s.deleteGeofence)
+	v1.GET("/geofence-events", s.getGeofenceEvents)
+	v1.GET("/traccar", s.getTraccar)
+	v1.GET("/webhooks", s.getWebhooks)
+	v1.POST("/webhooks", s.saveWebhook)
+	v1.GET("/webhooks/:id", s.getWebhook)
+	v1.PUT("/webhooks/:id", s.saveWebhook)
+	v1.DELETE("/webhooks/:id", s.deleteWebhook)
+	v1.POST("/webhooks/:id/ping", s.pingWebhook)
+	v1.GET("/webhooks/:id/deliveries", s.getDeliveries)
 }
 
 func (s *Server) getKeys(c *gin.Context) {
-	var keyAliases []models.KeyAlias
 	keys := maps.Keys(s.keyMap)
-	tx := s.db.Model(&models.KeyAlias{}).Where("key_id IN ?", keys).Find(&keyAliases)
-	if tx.Error != nil {
-		logger.Errorf("unable to fetch key aliases: %v", tx.Error)
+	keyAliases, err := s.store.KeyAliases(c.Request.Context(), keys)
+	if err != nil {
+		logger.Errorf("unable to fetch key aliases: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to fetch key aliases"})
 		return
 	}
@@ -119,8 +123,8 @@ func (s *Server) getKeys(c *gin.Context) {
 		if !ok {
 			ka = models.KeyAlias{KeyID: k}
 		}
-		lastLocation, err := s.getLastLocationByID(nil, k)
-		if err != nil {
+		lastLocation, err := s.getLastLocationByID(c.Request.Context(), k)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
 			logger.Warnf("unable to get last location: %v", err)
 		}
 		res = append(res, responses.Key{
@@ -159,85 +163,27 @@ func (s *Server) getLocationHistory(c *gin.Context) {
 }
 
 func (s *Server) getLocationBetweenInterval(ctx context.Context, startTime time.Time, endTime time.Time, key model.MainKey) ([]Location, error) {
-	var locations []Location
-	tx := s.db.
-		WithContext(ctx).
-		Find(&locations, "key_id = ? AND found_at BETWEEN ? AND ?", key.ID(), startTime, endTime)
-	if tx.Error != nil {
-		return nil, fmt.Errorf("unable to fetch locations: %w", tx.Error)
-	}
-	return locations, nil
-}
-
-func (s *Server) getLocation(ctx context.Context, amountHours int, key model.MainKey) ([]searchparty.TagData, error) {
-	lostAt := s.getLostAt(ctx, key)
-	reports, subKeysMap, err := s.c.Find(ctx, []model.MainKey{key}, amountHours, lostAt)
+	locations, err := s.store.Locations(ctx, key.ID(), startTime, endTime)
 	if err != nil {
 		return nil, err
 	}
-
-	tagData := make([]searchparty.TagData, 0)
-	for _, r := range reports {
-		payloadBytes, err := base64.StdEncoding.DecodeString(r.Payload)
-		if err != nil {
-			logger.Errorf("unable to decode payload: %v", err)
-			continue
-		}
-		key, ok := subKeysMap[r.ID]
-		if !ok {
-			logger.Errorf("unable to find key for report %s", r.ID)
-			continue
-		}
-		td, err := searchparty.DecodeReport(r, key)
-		if err != nil {
-			logger.Errorf("unable to decode report: %v", err)
-			continue
-		}
-		p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{td.Lng, td.Lat}) //nolint:mnd
-		if err != nil {
-			logger.Errorf("unable to create point: %v", err)
-			continue
-		}
-
-		dbPoint := models.GeomPoint(*p)
-		tx := s.db.
-			WithContext(ctx).
-			Clauses(clause.OnConflict{DoNothing: true}).
-			Create(&models.Location{
-				ReportedAt:      time.Unix(r.DatePublished/1000, 0),
-				FoundAt:         td.Time,
-				KeyID:           key.MainKey.ID(),
-				CurrentKeyID:    base64.StdEncoding.EncodeToString(key.HashedAdvKey),
-				OriginalContent: payloadBytes,
-				Geometry:        &dbPoint,
-				Confidence:      td.Confidence,
-				Status:          td.Status,
-			})
-		if tx.Error != nil {
-			logger.Errorf("unable to insert location: %v", tx.Error)
+	res := make([]Location, 0, len(locations))
+	for _, l := range locations {
+		if l.Geometry == nil {
 			continue
 		}
-		tagData = append(tagData, *td)
+		res = append(res, newLocation(l))
 	}
-	return tagData, nil
+	return res, nil
 }
 
 func (s *Server) refreshLocation(c *gin.Context) {
 	keyID := c.Param("keyId")
 	keyID = dirtyKeyID(keyID)
 
-	amountHours := c.Query("amountHours")
-	if amountHours == "" {
-		amountHours = "12"
-	}
-	amountHoursInt, err := strconv.Atoi(amountHours)
+	from, to, err := parseRefreshInterval(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "amountHours must be an integer"})
-		return
-	}
-
-	if amountHoursInt < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "amountHours must be greater than 0"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -248,8 +194,17 @@ func (s *Server) refreshLocation(c *gin.Context) {
 		return
 	}
 
-	tagData, err := s.getLocation(c, amountHoursInt, key)
-	if err != nil {
+	tagData, err := ingest.Fetch(c, s.c, s.store, key, from, to)
+	switch {
+	case errors.Is(err, searchparty.ErrRateLimited):
+		logger.Warnf("rate limited while getting location: %v", err)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limited by Apple, try again later"})
+		return
+	case errors.Is(err, searchparty.ErrUnauthorized):
+		logger.Errorf("unauthorized while getting location: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Apple rejected the credentials"})
+		return
+	case err != nil:
 		logger.Errorf("unable to get location: %v", err)
 		c.JSON(http.StatusInternalServerError, map[string]any{"error": err})
 		return
@@ -258,15 +213,41 @@ func (s *Server) refreshLocation(c *gin.Context) {
 	c.JSON(http.StatusOK, map[string]any{"tag_data": tagData})
 }
 
-func (s *Server) loadKeys(dir string) error {
-	keys, err := searchparty.LoadKeys(dir, s.beaconStoreKey)
+// parseRefreshInterval returns the interval requested via the "from" and "to"
+// query parameters (RFC3339), or the last "amountHours" (default: 12) hours.
+func parseRefreshInterval(c *gin.Context) (time.Time, time.Time, error) {
+	to := time.Now()
+	if toStr := c.Query("to"); toStr != "" {
+		t, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be a RFC3339 timestamp")
+		}
+		to = t
+	}
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		from, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be a RFC3339 timestamp")
+		}
+		if !from.Before(to) {
+			return time.Time{}, time.Time{}, errors.New("from must be before to")
+		}
+		return from, to, nil
+	}
+
+	amountHours := c.Query("amountHours")
+	if amountHours == "" {
+		amountHours = "12"
+	}
+	amountHoursInt, err := strconv.Atoi(amountHours)
 	if err != nil {
-		return fmt.Errorf("failed to load keys: %w", err)
+		return time.Time{}, time.Time{}, errors.New("amountHours must be an integer")
 	}
-	for _, k := range keys {
-		s.keyMap[k.ID()] = k
+	if amountHoursInt < 1 {
+		return time.Time{}, time.Time{}, errors.New("amountHours must be greater than 0")
 	}
-	return nil
+	return to.Add(-time.Duration(amountHoursInt) * time.Hour), to, nil
 }
 
 func (s *Server) getLastLocation(c *gin.Context) {
@@ -279,6 +260,10 @@ func (s *Server) getLastLocation(c *gin.Context) {
 	}
 
 	locationRes, err := s.getLastLocationByID(c.Request.Context(), keyID)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no location found"})
+		return
+	}
 	if err != nil {
 		logger.Errorf("unable to get last location: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to get last location"})
@@ -288,39 +273,10 @@ func (s *Server) getLastLocation(c *gin.Context) {
 }
 
 func (s *Server) getLastLocationByID(ctx context.Context, keyID string) (*models.LocationResult, error) {
-	var location models.Location
-	tx := s.db.
-		WithContext(ctx).
-		Where("key_id = ?", keyID).
-		Order("found_at desc").
-		First(&location)
-	if tx.Error != nil {
-		return nil, fmt.Errorf("unable to fetch location: %w", tx.Error)
-	}
-	return &models.LocationResult{
-		FoundAt:    location.FoundAt,
-		ReportedAt: location.ReportedAt,
-		KeyID:      location.KeyID,
-		Lat:        location.Geometry.Coords().Y(),
-		Lng:        location.Geometry.Coords().X(),
-		Confidence: location.Confidence,
-		Status:     location.Status,
-	}, nil
-}
-
-func (s *Server) getLostAt(ctx context.Context, key model.MainKey) time.Time {
-	var k models.KeyInfo
-	tx := s.db.
-		WithContext(ctx).
-		Model(&models.KeyInfo{}).
-		Where("id = ?", key.ID()).First(&k)
-	if tx.Error != nil {
-		logger.Errorf("unable to fetch key info: %v", tx.Error)
-		return time.Now()
-	}
-	lostAt := k.LostAt
-	if lostAt == nil {
-		return time.Now()
+	location, err := s.store.LastLocation(ctx, keyID, time.Time{})
+	if err != nil {
+		return nil, err
 	}
-	return *lostAt
+	res := newLocationResult(*location)
+	return &res, nil
 }
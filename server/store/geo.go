@@ -0,0 +1,115 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// earthRadius is the mean radius of the Earth, in meters
+const earthRadius = 6371000.0
+
+const (
+	// DefaultSearchPeriod is how far back an AreaQuery looks when From isn't set
+	DefaultSearchPeriod = 7 * 24 * time.Hour
+	// MaxSearchResults caps the number of locations returned by an AreaQuery
+	MaxSearchResults = 10000
+)
+
+// BoundingBox is an area delimited by two latitudes and two longitudes
+type BoundingBox struct {
+	MinLat float64 `json:"minLat"`
+	MinLng float64 `json:"minLng"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLng float64 `json:"maxLng"`
+}
+
+// Contains returns whether the point is inside b
+func (b BoundingBox) Contains(lat float64, lng float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lng >= b.MinLng && lng <= b.MaxLng
+}
+
+// Circle is the area within Radius meters of a point
+type Circle struct {
+	Lat    float64 `json:"lat"`
+	Lng    float64 `json:"lng"`
+	Radius float64 `json:"radius"`
+}
+
+// Contains returns whether the point is inside c
+func (c Circle) Contains(lat float64, lng float64) bool {
+	return Distance(c.Lat, c.Lng, lat, lng) <= c.Radius
+}
+
+// AreaQuery selects the locations found within an area during a time range.
+// Exactly one of BoundingBox and Circle must be set.
+type AreaQuery struct {
+	// KeyIDs restricts the query to some keys, all the keys if empty
+	KeyIDs      []string
+	From        time.Time
+	To          time.Time
+	BoundingBox *BoundingBox
+	Circle      *Circle
+	// Limit is the maximum number of locations returned, 0 for no limit
+	Limit int
+}
+
+// SetDefaults fills the unset fields of q: To defaults to now, From to
+// DefaultSearchPeriod before To and Limit to MaxSearchResults
+func (q *AreaQuery) SetDefaults() {
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-DefaultSearchPeriod)
+	}
+	if q.Limit == 0 {
+		q.Limit = MaxSearchResults
+	}
+}
+
+// Validate checks that the query describes a valid area and time range
+func (q AreaQuery) Validate() error {
+	if !q.To.IsZero() && !q.From.Before(q.To) {
+		return errors.New("from must be before to")
+	}
+	if q.Limit < 0 || q.Limit > MaxSearchResults {
+		return fmt.Errorf("limit must be between 1 and %d", MaxSearchResults)
+	}
+	switch {
+	case (q.BoundingBox == nil) == (q.Circle == nil):
+		return errors.New("either a bounding box or a circle is required")
+	case q.BoundingBox != nil:
+		b := q.BoundingBox
+		if !ValidLatLng(b.MinLat, b.MinLng) || !ValidLatLng(b.MaxLat, b.MaxLng) {
+			return errors.New("invalid bounding box coordinates")
+		}
+		if b.MinLat > b.MaxLat || b.MinLng > b.MaxLng {
+			return errors.New("the bounding box minimum must not exceed its maximum")
+		}
+	case q.Circle != nil:
+		if !ValidLatLng(q.Circle.Lat, q.Circle.Lng) {
+			return errors.New("invalid circle center")
+		}
+		if !(q.Circle.Radius > 0) || math.IsInf(q.Circle.Radius, 0) {
+			return errors.New("the radius must be a positive number")
+		}
+	}
+	return nil
+}
+
+// ValidLatLng returns whether lat and lng are valid WGS84 coordinates
+func ValidLatLng(lat float64, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// Distance returns the great-circle distance in meters between two points
+func Distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	rad := math.Pi / 180 //nolint:mnd
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
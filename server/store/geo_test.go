@@ -0,0 +1,42 @@
+package store
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDistance(t *testing.T) {
+	// Zurich HB to Bern Bahnhof
+	d := Distance(47.3779, 8.5403, 46.9490, 7.4392)
+	if math.Abs(d-95500) > 1000 {
+		t.Fatalf("unexpected distance: %f", d)
+	}
+}
+
+func TestAreaQueryDefaults(t *testing.T) {
+	q := AreaQuery{Circle: &Circle{Lat: 47.3779, Lng: 8.5403, Radius: 100}}
+	q.SetDefaults()
+	if q.To.IsZero() || q.To.Sub(q.From) != DefaultSearchPeriod || q.Limit != MaxSearchResults {
+		t.Fatalf("unexpected defaults %+v", q)
+	}
+	if err := q.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	to := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	q = AreaQuery{From: to, To: to, Circle: q.Circle}
+	if err := q.Validate(); err == nil {
+		t.Errorf("expected an error for an empty time range")
+	}
+	q = AreaQuery{Limit: MaxSearchResults + 1, Circle: q.Circle}
+	if err := q.Validate(); err == nil {
+		t.Errorf("expected an error for a limit above %d", MaxSearchResults)
+	}
+	for _, radius := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		q = AreaQuery{Circle: &Circle{Lat: 47.3779, Lng: 8.5403, Radius: radius}}
+		if err := q.Validate(); err == nil {
+			t.Errorf("expected an error for a radius of %v", radius)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+func (s *gormStore) Geofences(ctx context.Context) ([]models.Geofence, error) {
+	var geofences []models.Geofence
+	tx := s.db.
+		WithContext(ctx).
+		Order("id asc").
+		Find(&geofences)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch geofences: %w", tx.Error)
+	}
+	return geofences, nil
+}
+
+func (s *gormStore) Geofence(ctx context.Context, id uint) (*models.Geofence, error) {
+	var geofence models.Geofence
+	tx := s.db.
+		WithContext(ctx).
+		First(&geofence, id)
+	if tx.Error != nil {
+		return nil, notFound(tx.Error, "unable to fetch geofence")
+	}
+	return &geofence, nil
+}
+
+func (s *gormStore) SaveGeofence(ctx context.Context, geofence *models.Geofence) error {
+	if geofence.ID == 0 {
+		if err := s.db.WithContext(ctx).Create(geofence).Error; err != nil {
+			return fmt.Errorf("unable to create geofence: %w", err)
+		}
+		return nil
+	}
+	tx := s.db.
+		WithContext(ctx).
+		Model(geofence).
+		Select("*").
+		Omit("created_at").
+		Updates(geofence)
+	if tx.Error != nil {
+		return fmt.Errorf("unable to update geofence: %w", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *gormStore) DeleteGeofence(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("geofence_id = ?", id).Delete(&models.GeofenceEvent{}).Error; err != nil {
+			return fmt.Errorf("unable to delete geofence events: %w", err)
+		}
+		if err := tx.Where("geofence_id = ?", id).Delete(&models.GeofenceState{}).Error; err != nil {
+			return fmt.Errorf("unable to delete geofence states: %w", err)
+		}
+		res := tx.Delete(&models.Geofence{}, id)
+		if res.Error != nil {
+			return fmt.Errorf("unable to delete geofence: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *gormStore) GeofenceState(ctx context.Context, geofenceID uint, keyID string) (*models.GeofenceState, error) {
+	var state models.GeofenceState
+	tx := s.db.
+		WithContext(ctx).
+		Where("geofence_id = ? AND key_id = ?", geofenceID, keyID).
+		First(&state)
+	if tx.Error != nil {
+		return nil, notFound(tx.Error, "unable to fetch geofence state")
+	}
+	return &state, nil
+}
+
+func (s *gormStore) SaveGeofenceState(ctx context.Context, state *models.GeofenceState) error {
+	st := *state
+	st.LastFoundAt = st.LastFoundAt.UTC()
+	if st.EnteredAt != nil {
+		enteredAt := st.EnteredAt.UTC()
+		st.EnteredAt = &enteredAt
+	}
+	tx := s.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "geofence_id"}, {Name: "key_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"inside", "entered_at", "dwell_notified", "last_found_at"}),
+		}).
+		Create(&st)
+	if tx.Error != nil {
+		return fmt.Errorf("unable to save geofence state: %w", tx.Error)
+	}
+	return nil
+}
+
+func (s *gormStore) SaveGeofenceEvent(ctx context.Context, event *models.GeofenceEvent) error {
+	event.FoundAt = event.FoundAt.UTC()
+	if err := s.db.WithContext(ctx).Create(event).Error; err != nil {
+		return fmt.Errorf("unable to save geofence event: %w", err)
+	}
+	return nil
+}
+
+func (s *gormStore) GeofenceEvents(ctx context.Context, q GeofenceEventQuery) ([]models.GeofenceEvent, error) {
+	db := s.db.
+		WithContext(ctx).
+		Order("found_at desc, id desc")
+	if q.KeyID != "" {
+		db = db.Where("key_id = ?", q.KeyID)
+	}
+	if q.GeofenceID != 0 {
+		db = db.Where("geofence_id = ?", q.GeofenceID)
+	}
+	if !q.From.IsZero() {
+		db = db.Where("found_at >= ?", q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		db = db.Where("found_at <= ?", q.To.UTC())
+	}
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit)
+	}
+	var events []models.GeofenceEvent
+	if err := db.Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("unable to fetch geofence events: %w", err)
+	}
+	return events, nil
+}
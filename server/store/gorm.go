@@ -0,0 +1,342 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+// gormStore implements Store on top of gorm, the queries are shared by the
+// PostgreSQL and SQLite stores. The times are stored in UTC, since SQLite
+// compares them as strings.
+type gormStore struct {
+	db      *gorm.DB
+	dialect string
+
+	// mu guards lastCreatedAt, the creation time of the last location
+	mu            sync.Mutex
+	lastCreatedAt time.Time
+}
+
+var _ Store = (*gormStore)(nil)
+
+func (s *gormStore) SaveLocation(ctx context.Context, location *models.Location) (bool, error) {
+	l := *location
+	l.FoundAt = l.FoundAt.UTC()
+	l.ReportedAt = l.ReportedAt.UTC()
+	l.CreatedAt = s.nextCreatedAt()
+	tx := s.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&l)
+	if tx.Error != nil {
+		return false, fmt.Errorf("unable to insert location: %w", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return false, nil
+	}
+	location.CreatedAt = l.CreatedAt
+	return true, nil
+}
+
+// nextCreatedAt returns the creation time of a new location, after the one
+// of the previous location even if the clock goes back
+func (s *gormStore) nextCreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := time.Now().UTC()
+	if !t.After(s.lastCreatedAt) {
+		t = s.lastCreatedAt.Add(time.Nanosecond)
+	}
+	s.lastCreatedAt = t
+	return t
+}
+
+func (s *gormStore) LastLocation(ctx context.Context, keyID string, before time.Time) (*models.Location, error) {
+	var location models.Location
+	q := s.db.
+		WithContext(ctx).
+		Where("key_id = ?", keyID)
+	if !before.IsZero() {
+		q = q.Where("found_at < ?", before.UTC())
+	}
+	tx := q.Order("found_at desc").First(&location)
+	if tx.Error != nil {
+		return nil, notFound(tx.Error, "unable to fetch location")
+	}
+	return &location, nil
+}
+
+func (s *gormStore) Locations(ctx context.Context, keyID string, from time.Time, to time.Time) ([]models.Location, error) {
+	var locations []models.Location
+	tx := s.db.
+		WithContext(ctx).
+		Where("key_id = ? AND found_at BETWEEN ? AND ?", keyID, from.UTC(), to.UTC()).
+		Order("found_at desc").
+		Find(&locations)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch locations: %w", tx.Error)
+	}
+	return locations, nil
+}
+
+func (s *gormStore) LocationsCreatedAfter(ctx context.Context, keyIDs []string, after time.Time, limit int) ([]models.Location, error) {
+	var locations []models.Location
+	q := s.db.
+		WithContext(ctx).
+		Where("created_at > ? AND geometry IS NOT NULL", after.UTC())
+	if len(keyIDs) > 0 {
+		q = q.Where("key_id IN ?", keyIDs)
+	}
+	tx := q.Order("created_at desc").Limit(limit).Find(&locations)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch locations: %w", tx.Error)
+	}
+	return locations, nil
+}
+
+func (s *gormStore) LocationsWithin(ctx context.Context, q AreaQuery) ([]models.Location, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	db := s.db.
+		WithContext(ctx).
+		Where("found_at BETWEEN ? AND ?", q.From.UTC(), q.To.UTC()).
+		Order("found_at desc")
+	if len(q.KeyIDs) > 0 {
+		db = db.Where("key_id IN ?", q.KeyIDs)
+	}
+
+	if s.dialect == dialectPostgres {
+		// Filter with PostGIS, using the spatial index
+		if b := q.BoundingBox; b != nil {
+			db = db.Where("ST_Intersects(geometry, ST_MakeEnvelope(?, ?, ?, ?, 4326))", b.MinLng, b.MinLat, b.MaxLng, b.MaxLat)
+		} else {
+			c := q.Circle
+			db = db.Where("ST_DWithin(geometry::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)", c.Lng, c.Lat, c.Radius)
+		}
+		if q.Limit > 0 {
+			db = db.Limit(q.Limit)
+		}
+		var locations []models.Location
+		if err := db.Find(&locations).Error; err != nil {
+			return nil, fmt.Errorf("unable to fetch locations: %w", err)
+		}
+		return locations, nil
+	}
+
+	// SQLite has no spatial functions: prefilter on the time range and the
+	// keys, then filter the area while scanning
+	rows, err := db.Model(&models.Location{}).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch locations: %w", err)
+	}
+	defer rows.Close()
+	var locations []models.Location
+	for rows.Next() {
+		var l models.Location
+		if err := s.db.ScanRows(rows, &l); err != nil {
+			return nil, fmt.Errorf("unable to scan location: %w", err)
+		}
+		if l.Geometry == nil {
+			continue
+		}
+		lat, lng := l.Geometry.Coords().Y(), l.Geometry.Coords().X()
+		if (q.BoundingBox != nil && !q.BoundingBox.Contains(lat, lng)) || (q.Circle != nil && !q.Circle.Contains(lat, lng)) {
+			continue
+		}
+		locations = append(locations, l)
+		if q.Limit > 0 && len(locations) >= q.Limit {
+			break
+		}
+	}
+	return locations, rows.Err()
+}
+
+func (s *gormStore) LocationKeyIDs(ctx context.Context) ([]string, error) {
+	var keyIDs []string
+	tx := s.db.
+		WithContext(ctx).
+		Model(&models.Location{}).
+		Distinct("key_id").
+		Pluck("key_id", &keyIDs)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch key ids: %w", tx.Error)
+	}
+	return keyIDs, nil
+}
+
+func (s *gormStore) LocationSamples(ctx context.Context, keyID string, from time.Time, to time.Time) ([]LocationSample, error) {
+	var samples []LocationSample
+	tx := s.db.
+		WithContext(ctx).
+		Model(&models.Location{}).
+		Select("found_at", "confidence").
+		Where("key_id = ? AND found_at BETWEEN ? AND ?", keyID, from.UTC(), to.UTC()).
+		Order("found_at asc").
+		Scan(&samples)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch locations: %w", tx.Error)
+	}
+	return samples, nil
+}
+
+// deleteBatchSize bounds the number of parameters of a delete
+const deleteBatchSize = 500
+
+func (s *gormStore) DeleteLocations(ctx context.Context, keyID string, foundAt []time.Time) (int64, error) {
+	var deleted int64
+	for start := 0; start < len(foundAt); start += deleteBatchSize {
+		end := min(start+deleteBatchSize, len(foundAt))
+		batch := make([]time.Time, 0, end-start)
+		for _, t := range foundAt[start:end] {
+			batch = append(batch, t.UTC())
+		}
+		tx := s.db.
+			WithContext(ctx).
+			Where("key_id = ? AND found_at IN ?", keyID, batch).
+			Delete(&models.Location{})
+		if tx.Error != nil {
+			return deleted, fmt.Errorf("unable to delete locations: %w", tx.Error)
+		}
+		deleted += tx.RowsAffected
+	}
+	return deleted, nil
+}
+
+func (s *gormStore) DeleteLocationsBefore(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	q := s.db.
+		WithContext(ctx).
+		Model(&models.Location{}).
+		Where("found_at < ?", before.UTC())
+	if dryRun {
+		var count int64
+		if err := q.Count(&count).Error; err != nil {
+			return 0, fmt.Errorf("unable to count locations: %w", err)
+		}
+		return count, nil
+	}
+	tx := q.Delete(&models.Location{})
+	if tx.Error != nil {
+		return 0, fmt.Errorf("unable to delete locations: %w", tx.Error)
+	}
+	return tx.RowsAffected, nil
+}
+
+func (s *gormStore) ClearOriginalContent(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	q := s.db.
+		WithContext(ctx).
+		Model(&models.Location{}).
+		Where("found_at < ? AND original_content IS NOT NULL", before.UTC())
+	if dryRun {
+		var count int64
+		if err := q.Count(&count).Error; err != nil {
+			return 0, fmt.Errorf("unable to count locations: %w", err)
+		}
+		return count, nil
+	}
+	tx := q.Update("original_content", nil)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("unable to clear original content: %w", tx.Error)
+	}
+	return tx.RowsAffected, nil
+}
+
+func (s *gormStore) KeyInfo(ctx context.Context, keyID string) (*models.KeyInfo, error) {
+	var info models.KeyInfo
+	tx := s.db.
+		WithContext(ctx).
+		Where("id = ?", keyID).
+		First(&info)
+	if tx.Error != nil {
+		return nil, notFound(tx.Error, "unable to fetch key info")
+	}
+	return &info, nil
+}
+
+func (s *gormStore) KeyInfos(ctx context.Context) ([]models.KeyInfo, error) {
+	var infos []models.KeyInfo
+	tx := s.db.
+		WithContext(ctx).
+		Find(&infos)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch key infos: %w", tx.Error)
+	}
+	return infos, nil
+}
+
+func (s *gormStore) SetLastFetchedAt(ctx context.Context, keyID string, t time.Time) error {
+	t = t.UTC()
+	return s.upsertKeyInfo(ctx, &models.KeyInfo{ID: keyID, LastFetchedAt: &t}, "last_fetched_at")
+}
+
+func (s *gormStore) SetPollingBounds(ctx context.Context, keyID string, minSeconds *int, maxSeconds *int) error {
+	return s.upsertKeyInfo(ctx, &models.KeyInfo{ID: keyID, MinPollSeconds: minSeconds, MaxPollSeconds: maxSeconds},
+		"min_poll_seconds", "max_poll_seconds",
+	)
+}
+
+func (s *gormStore) SetLostAt(ctx context.Context, keyID string, lostAt *time.Time) error {
+	if lostAt != nil {
+		t := lostAt.UTC()
+		lostAt = &t
+	}
+	return s.upsertKeyInfo(ctx, &models.KeyInfo{ID: keyID, LostAt: lostAt}, "lost_at")
+}
+
+func (s *gormStore) SetTraccarEnabled(ctx context.Context, keyID string, enabled bool) error {
+	return s.upsertKeyInfo(ctx, &models.KeyInfo{ID: keyID, TraccarEnabled: enabled}, "traccar_enabled")
+}
+
+// upsertKeyInfo creates info, or updates columns if the key already has an info
+func (s *gormStore) upsertKeyInfo(ctx context.Context, info *models.KeyInfo, columns ...string) error {
+	tx := s.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns(columns),
+		}).
+		Create(info)
+	if tx.Error != nil {
+		return fmt.Errorf("unable to save key info: %w", tx.Error)
+	}
+	return nil
+}
+
+func (s *gormStore) KeyAliases(ctx context.Context, keyIDs []string) ([]models.KeyAlias, error) {
+	var aliases []models.KeyAlias
+	if len(keyIDs) == 0 {
+		return aliases, nil
+	}
+	tx := s.db.
+		WithContext(ctx).
+		Where("key_id IN ?", keyIDs).
+		Find(&aliases)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch key aliases: %w", tx.Error)
+	}
+	return aliases, nil
+}
+
+func (s *gormStore) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// notFound maps gorm.ErrRecordNotFound to ErrNotFound
+func notFound(err error, msg string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+// LocationHook is notified of the locations stored for the first time
+type LocationHook interface {
+	LocationSaved(ctx context.Context, location *models.Location)
+}
+
+type hookedStore struct {
+	Store
+	hooks []LocationHook
+}
+
+// WithHooks returns a Store notifying hooks of every new location saved in st
+func WithHooks(st Store, hooks ...LocationHook) Store {
+	return &hookedStore{Store: st, hooks: hooks}
+}
+
+func (s *hookedStore) SaveLocation(ctx context.Context, location *models.Location) (bool, error) {
+	saved, err := s.Store.SaveLocation(ctx, location)
+	if err != nil || !saved {
+		return saved, err
+	}
+	for _, h := range s.hooks {
+		h.LocationSaved(ctx, location)
+	}
+	return true, nil
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer version
+var ErrSchemaTooNew = errors.New("database schema is newer than supported, upgrade searchparty")
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and whether it's applied
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// schemaMigration is a row of the table tracking the applied migrations
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies the embedded migrations of a dialect
+type Migrator struct {
+	db         *gorm.DB
+	dialect    string
+	migrations []Migration
+}
+
+func newMigrator(db *gorm.DB, dialect string) (*Migrator, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// loadMigrations reads the migrations of dialect, named
+// <version>_<name>.(up|down).sql
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", e.Name())
+		}
+		versionStr, name, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", e.Name(), err)
+		}
+		b, err := fs.ReadFile(migrationsFS, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest returns the version of the most recent migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the schema, 0 if no migration was applied
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.db.WithContext(ctx).AutoMigrate(&schemaMigration{}); err != nil {
+		return 0, fmt.Errorf("unable to create migrations table: %w", err)
+	}
+	var version int
+	tx := m.db.
+		WithContext(ctx).
+		Model(&schemaMigration{}).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&version)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("unable to get schema version: %w", tx.Error)
+	}
+	return version, nil
+}
+
+// Status returns all the migrations and whether they are applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		res = append(res, MigrationStatus{Migration: mig, Applied: mig.Version <= version})
+	}
+	return res, nil
+}
+
+// Up applies all the pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// To migrates the schema up or down to version, 0 reverts all the migrations
+func (m *Migrator) To(ctx context.Context, version int) error {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w: schema version %d, latest known %d", ErrSchemaTooNew, current, m.Latest())
+	}
+	if version < 0 || version > m.Latest() {
+		return fmt.Errorf("unknown schema version %d", version)
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version <= current || mig.Version > version {
+			continue
+		}
+		logger.Infof("applying migration %d (%s)", mig.Version, mig.Name)
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mig.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", mig.Version, mig.Name, err)
+		}
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version > current || mig.Version <= version {
+			continue
+		}
+		if mig.Down == "" {
+			return fmt.Errorf("migration %d (%s) can't be reverted", mig.Version, mig.Name)
+		}
+		logger.Infof("reverting migration %d (%s)", mig.Version, mig.Name)
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mig.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: mig.Version}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d (%s) failed: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// check returns an error unless the schema is at the latest version
+func (m *Migrator) check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version > m.Latest():
+		return fmt.Errorf("%w: schema version %d, latest known %d", ErrSchemaTooNew, version, m.Latest())
+	case version < m.Latest():
+		return fmt.Errorf("database schema is outdated (version %d, latest %d), run the migrate command", version, m.Latest())
+	}
+	return nil
+}
+
+func (m *Migrator) Close() error {
+	db, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
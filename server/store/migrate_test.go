@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "searchparty.db")
+
+	// The schema must be migrated unless autoMigrate is set
+	if _, err := Open(ctx, dsn, false); err == nil {
+		t.Fatalf("expected an error opening an empty database without migrating")
+	}
+
+	m, err := NewMigrator(dsn)
+	if err != nil {
+		t.Fatalf("unable to create migrator: %v", err)
+	}
+	defer m.Close()
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("unable to migrate: %v", err)
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		t.Fatalf("unable to get version: %v", err)
+	}
+	if version != m.Latest() {
+		t.Fatalf("expected version %d, got %d", m.Latest(), version)
+	}
+
+	// Migrating down and up again must be possible
+	if err := m.To(ctx, 0); err != nil {
+		t.Fatalf("unable to revert the migrations: %v", err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("unable to migrate again: %v", err)
+	}
+	s, err := Open(ctx, dsn, false)
+	if err != nil {
+		t.Fatalf("unable to open migrated store: %v", err)
+	}
+	if err := s.SetLastFetchedAt(ctx, "key", time.Now()); err != nil {
+		t.Fatalf("unable to use migrated store: %v", err)
+	}
+	_ = s.Close()
+
+	// A schema migrated by a newer version is refused
+	tx := m.db.Create(&schemaMigration{Version: m.Latest() + 1, Name: "future", AppliedAt: time.Now()})
+	if tx.Error != nil {
+		t.Fatalf("unable to insert future migration: %v", tx.Error)
+	}
+	if _, err := Open(ctx, dsn, true); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+// TestMigrationsAligned checks that a version means the same migration for
+// every dialect, so that migrate --to and the schema version don't depend on
+// the database
+func TestMigrationsAligned(t *testing.T) {
+	postgres, err := loadMigrations("postgres")
+	if err != nil {
+		t.Fatalf("unable to load postgres migrations: %v", err)
+	}
+	sqlite, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatalf("unable to load sqlite migrations: %v", err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("expected the same number of migrations, got %d and %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d: postgres %d_%s, sqlite %d_%s", i, postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS key_infos;
+DROP TABLE IF EXISTS key_aliases;
+DROP TABLE IF EXISTS locations;
@@ -0,0 +1,31 @@
+-- Compatible with the schema created by gorm's AutoMigrate
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+CREATE TABLE IF NOT EXISTS locations (
+    found_at timestamptz NOT NULL,
+    reported_at timestamptz,
+    key_id text NOT NULL,
+    original_content bytea,
+    geometry geometry(POINT, 4326),
+    confidence bigint,
+    status bigint,
+    current_key_id text,
+    PRIMARY KEY (found_at, key_id)
+);
+CREATE INDEX IF NOT EXISTS idx_found_at ON locations (found_at);
+CREATE INDEX IF NOT EXISTS idx_reported_at ON locations (reported_at);
+CREATE INDEX IF NOT EXISTS idx_key_id ON locations (key_id);
+CREATE INDEX IF NOT EXISTS idx_geometry ON locations (geometry);
+CREATE INDEX IF NOT EXISTS idx_confidence ON locations (confidence);
+CREATE INDEX IF NOT EXISTS idx_current_key_id ON locations (current_key_id);
+
+CREATE TABLE IF NOT EXISTS key_aliases (
+    key_id text PRIMARY KEY,
+    alias text,
+    type text
+);
+
+CREATE TABLE IF NOT EXISTS key_infos (
+    id text PRIMARY KEY,
+    lost_at timestamptz
+);
@@ -0,0 +1,4 @@
+ALTER TABLE key_infos
+    DROP COLUMN IF EXISTS last_fetched_at,
+    DROP COLUMN IF EXISTS min_poll_seconds,
+    DROP COLUMN IF EXISTS max_poll_seconds;
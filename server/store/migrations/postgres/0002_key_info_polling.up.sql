@@ -0,0 +1,4 @@
+ALTER TABLE key_infos
+    ADD COLUMN IF NOT EXISTS last_fetched_at timestamptz,
+    ADD COLUMN IF NOT EXISTS min_poll_seconds bigint,
+    ADD COLUMN IF NOT EXISTS max_poll_seconds bigint;
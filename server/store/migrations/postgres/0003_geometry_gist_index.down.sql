@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_locations_geography_gist;
+DROP INDEX IF EXISTS idx_locations_geometry_gist;
@@ -0,0 +1,3 @@
+-- The index created by AutoMigrate is a btree, which the spatial queries can't use
+CREATE INDEX IF NOT EXISTS idx_locations_geometry_gist ON locations USING GIST (geometry);
+CREATE INDEX IF NOT EXISTS idx_locations_geography_gist ON locations USING GIST ((geometry::geography));
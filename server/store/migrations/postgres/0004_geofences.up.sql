@@ -0,0 +1,37 @@
+CREATE TABLE geofences (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    key_id text,
+    type text NOT NULL,
+    lat double precision,
+    lng double precision,
+    radius double precision,
+    polygon text,
+    dwell_seconds bigint NOT NULL DEFAULT 0,
+    created_at timestamptz
+);
+CREATE INDEX idx_geofence_key_id ON geofences (key_id);
+
+CREATE TABLE geofence_events (
+    id bigserial PRIMARY KEY,
+    geofence_id bigint NOT NULL,
+    key_id text NOT NULL,
+    type text NOT NULL,
+    found_at timestamptz NOT NULL,
+    lat double precision,
+    lng double precision,
+    created_at timestamptz
+);
+CREATE INDEX idx_geofence_event_geofence_id ON geofence_events (geofence_id);
+CREATE INDEX idx_geofence_event_key_id ON geofence_events (key_id);
+CREATE INDEX idx_geofence_event_found_at ON geofence_events (found_at);
+
+CREATE TABLE geofence_states (
+    geofence_id bigint NOT NULL,
+    key_id text NOT NULL,
+    inside boolean NOT NULL DEFAULT false,
+    entered_at timestamptz,
+    dwell_notified boolean NOT NULL DEFAULT false,
+    last_found_at timestamptz,
+    PRIMARY KEY (geofence_id, key_id)
+);
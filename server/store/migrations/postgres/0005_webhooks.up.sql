@@ -0,0 +1,25 @@
+CREATE TABLE webhooks (
+    id bigserial PRIMARY KEY,
+    url text NOT NULL,
+    secret text,
+    events text,
+    key_id text,
+    disabled boolean NOT NULL DEFAULT false,
+    created_at timestamptz
+);
+
+CREATE TABLE webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL,
+    event text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL,
+    attempts bigint NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    response_code bigint,
+    last_error text,
+    delivered_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX idx_webhook_delivery_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX idx_webhook_delivery_status ON webhook_deliveries (status, next_attempt_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS traccar_outbox;
+ALTER TABLE key_infos DROP COLUMN traccar_enabled;
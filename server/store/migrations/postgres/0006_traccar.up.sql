@@ -0,0 +1,19 @@
+ALTER TABLE key_infos ADD COLUMN traccar_enabled boolean NOT NULL DEFAULT false;
+
+CREATE TABLE traccar_outbox (
+    id bigserial PRIMARY KEY,
+    key_id text NOT NULL,
+    found_at timestamptz NOT NULL,
+    lat double precision,
+    lng double precision,
+    accuracy bigint,
+    battery bigint,
+    status text NOT NULL DEFAULT 'pending',
+    attempts bigint NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    last_error text,
+    created_at timestamptz
+);
+CREATE UNIQUE INDEX idx_traccar_outbox_location ON traccar_outbox (key_id, found_at);
+CREATE INDEX idx_traccar_outbox_next_attempt_at ON traccar_outbox (next_attempt_at);
+CREATE INDEX idx_traccar_outbox_status ON traccar_outbox (status);
@@ -0,0 +1,3 @@
+DROP TABLE key_infos;
+DROP TABLE key_aliases;
+DROP TABLE locations;
@@ -0,0 +1,28 @@
+-- The geometry is stored as a raw EWKB blob, SQLite has no geometry type
+CREATE TABLE locations (
+    found_at DATETIME NOT NULL,
+    reported_at DATETIME,
+    key_id TEXT NOT NULL,
+    original_content BLOB,
+    geometry BLOB,
+    confidence INTEGER,
+    status INTEGER,
+    current_key_id TEXT,
+    PRIMARY KEY (found_at, key_id)
+);
+CREATE INDEX idx_found_at ON locations (found_at);
+CREATE INDEX idx_reported_at ON locations (reported_at);
+CREATE INDEX idx_key_id ON locations (key_id);
+CREATE INDEX idx_confidence ON locations (confidence);
+CREATE INDEX idx_current_key_id ON locations (current_key_id);
+
+CREATE TABLE key_aliases (
+    key_id TEXT PRIMARY KEY,
+    alias TEXT,
+    type TEXT
+);
+
+CREATE TABLE key_infos (
+    id TEXT PRIMARY KEY,
+    lost_at DATETIME
+);
@@ -0,0 +1,3 @@
+ALTER TABLE key_infos DROP COLUMN max_poll_seconds;
+ALTER TABLE key_infos DROP COLUMN min_poll_seconds;
+ALTER TABLE key_infos DROP COLUMN last_fetched_at;
@@ -0,0 +1,3 @@
+ALTER TABLE key_infos ADD COLUMN last_fetched_at DATETIME;
+ALTER TABLE key_infos ADD COLUMN min_poll_seconds INTEGER;
+ALTER TABLE key_infos ADD COLUMN max_poll_seconds INTEGER;
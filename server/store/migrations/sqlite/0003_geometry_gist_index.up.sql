@@ -0,0 +1 @@
+-- SQLite has no GIST index, this migration keeps the versions aligned with PostgreSQL
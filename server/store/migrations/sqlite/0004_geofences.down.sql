@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS geofence_states;
+DROP TABLE IF EXISTS geofence_events;
+DROP TABLE IF EXISTS geofences;
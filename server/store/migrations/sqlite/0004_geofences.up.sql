@@ -0,0 +1,37 @@
+CREATE TABLE geofences (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    key_id TEXT,
+    type TEXT NOT NULL,
+    lat REAL,
+    lng REAL,
+    radius REAL,
+    polygon TEXT,
+    dwell_seconds INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME
+);
+CREATE INDEX idx_geofence_key_id ON geofences (key_id);
+
+CREATE TABLE geofence_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    geofence_id INTEGER NOT NULL,
+    key_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    found_at DATETIME NOT NULL,
+    lat REAL,
+    lng REAL,
+    created_at DATETIME
+);
+CREATE INDEX idx_geofence_event_geofence_id ON geofence_events (geofence_id);
+CREATE INDEX idx_geofence_event_key_id ON geofence_events (key_id);
+CREATE INDEX idx_geofence_event_found_at ON geofence_events (found_at);
+
+CREATE TABLE geofence_states (
+    geofence_id INTEGER NOT NULL,
+    key_id TEXT NOT NULL,
+    inside BOOLEAN NOT NULL DEFAULT 0,
+    entered_at DATETIME,
+    dwell_notified BOOLEAN NOT NULL DEFAULT 0,
+    last_found_at DATETIME,
+    PRIMARY KEY (geofence_id, key_id)
+);
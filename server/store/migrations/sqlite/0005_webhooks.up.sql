@@ -0,0 +1,25 @@
+CREATE TABLE webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    secret TEXT,
+    events TEXT,
+    key_id TEXT,
+    disabled BOOLEAN NOT NULL DEFAULT 0,
+    created_at DATETIME
+);
+
+CREATE TABLE webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    response_code INTEGER,
+    last_error TEXT,
+    delivered_at DATETIME,
+    created_at DATETIME
+);
+CREATE INDEX idx_webhook_delivery_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX idx_webhook_delivery_status ON webhook_deliveries (status, next_attempt_at);
@@ -0,0 +1,19 @@
+ALTER TABLE key_infos ADD COLUMN traccar_enabled BOOLEAN NOT NULL DEFAULT 0;
+
+CREATE TABLE traccar_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    key_id TEXT NOT NULL,
+    found_at DATETIME NOT NULL,
+    lat REAL,
+    lng REAL,
+    accuracy INTEGER,
+    battery INTEGER,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_error TEXT,
+    created_at DATETIME
+);
+CREATE UNIQUE INDEX idx_traccar_outbox_location ON traccar_outbox (key_id, found_at);
+CREATE INDEX idx_traccar_outbox_next_attempt_at ON traccar_outbox (next_attempt_at);
+CREATE INDEX idx_traccar_outbox_status ON traccar_outbox (status);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_created_at;
+ALTER TABLE locations DROP COLUMN created_at;
@@ -0,0 +1,6 @@
+-- created_at is when the location was stored. The existing locations are
+-- backfilled with their found_at, for them it's the time of the report and
+-- not when they were stored.
+ALTER TABLE locations ADD COLUMN created_at DATETIME;
+UPDATE locations SET created_at = found_at;
+CREATE INDEX idx_created_at ON locations (created_at);
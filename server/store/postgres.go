@@ -0,0 +1,21 @@
+package store
+
+import (
+	"fmt"
+
+	_ "github.com/lib/pq"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// openPostgres connects to the PostgreSQL (PostGIS) database at dsn
+func openPostgres(dsn string) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		DSN:        dsn,
+		DriverName: "postgres",
+	}), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
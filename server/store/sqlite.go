@@ -0,0 +1,24 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// openSQLite opens (creating it if needed) the SQLite database at path.
+// The driver is pure Go, no cgo nor PostGIS is required.
+func openSQLite(path string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// SQLite doesn't support concurrent writers
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
@@ -0,0 +1,251 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+func newTestLocation(t *testing.T, keyID string, foundAt time.Time, lat float64, lng float64) *models.Location {
+	t.Helper()
+	p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{lng, lat})
+	if err != nil {
+		t.Fatalf("unable to create point: %v", err)
+	}
+	g := models.GeomPoint(*p)
+	return &models.Location{
+		FoundAt:    foundAt,
+		ReportedAt: foundAt.Add(time.Minute),
+		KeyID:      keyID,
+		Geometry:   &g,
+		Confidence: 2,
+	}
+}
+
+func TestSQLiteLocations(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer s.Close()
+
+	if _, err := s.LastLocation(ctx, "key", time.Time{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	var created []time.Time
+	for i := 0; i < 3; i++ {
+		l := newTestLocation(t, "key", now.Add(-time.Duration(i)*time.Hour), 47.37+float64(i)/100, 8.54)
+		if saved, err := s.SaveLocation(ctx, l); err != nil || !saved {
+			t.Fatalf("unable to save location: %v", err)
+		}
+		if len(created) > 0 && !l.CreatedAt.After(created[len(created)-1]) {
+			t.Fatalf("expected increasing creation times, got %s after %s", l.CreatedAt, created[len(created)-1])
+		}
+		created = append(created, l.CreatedAt)
+		// Duplicates are ignored
+		if saved, err := s.SaveLocation(ctx, l); err != nil || saved {
+			t.Fatalf("expected the duplicate location to be ignored: %v", err)
+		}
+	}
+	if _, err := s.SaveLocation(ctx, newTestLocation(t, "other", now, 0, 0)); err != nil {
+		t.Fatalf("unable to save location: %v", err)
+	}
+
+	last, err := s.LastLocation(ctx, "key", time.Time{})
+	if err != nil {
+		t.Fatalf("unable to get last location: %v", err)
+	}
+	if !last.FoundAt.Equal(now) {
+		t.Fatalf("expected last location found at %s, got %s", now, last.FoundAt)
+	}
+	if lat := last.Geometry.Coords().Y(); lat != 47.37 {
+		t.Fatalf("unexpected latitude %f", lat)
+	}
+
+	before, err := s.LastLocation(ctx, "key", now)
+	if err != nil {
+		t.Fatalf("unable to get last location: %v", err)
+	}
+	if !before.FoundAt.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("unexpected location found at %s", before.FoundAt)
+	}
+
+	locations, err := s.Locations(ctx, "key", now.Add(-90*time.Minute), now)
+	if err != nil {
+		t.Fatalf("unable to get locations: %v", err)
+	}
+	if len(locations) != 2 {
+		t.Fatalf("expected 2 locations, got %d", len(locations))
+	}
+	if !locations[0].FoundAt.After(locations[1].FoundAt) {
+		t.Fatalf("expected the newest location first")
+	}
+
+	// The locations stored after a cursor, the cursor itself excluded
+	locations, err = s.LocationsCreatedAfter(ctx, []string{"key"}, created[0], 10)
+	if err != nil {
+		t.Fatalf("unable to get locations: %v", err)
+	}
+	if len(locations) != 2 || !locations[0].CreatedAt.Equal(created[2]) || !locations[1].CreatedAt.Equal(created[1]) {
+		t.Fatalf("expected the 2 locations stored after the first one, most recent first, got %+v", locations)
+	}
+	if locations, err = s.LocationsCreatedAfter(ctx, nil, created[0], 10); err != nil || len(locations) != 3 {
+		t.Fatalf("expected the locations of all the keys, got %d %v", len(locations), err)
+	}
+	if locations, err = s.LocationsCreatedAfter(ctx, nil, created[0], 1); err != nil || len(locations) != 1 || locations[0].KeyID != "other" {
+		t.Fatalf("expected the most recently stored location, got %+v %v", locations, err)
+	}
+}
+
+func TestSQLiteKeyInfo(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer s.Close()
+
+	if _, err := s.KeyInfo(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	fetchedAt := time.Now().Truncate(time.Second)
+	if err := s.SetLastFetchedAt(ctx, "key", fetchedAt); err != nil {
+		t.Fatalf("unable to set last fetched at: %v", err)
+	}
+	minSeconds := 60
+	if err := s.SetPollingBounds(ctx, "key", &minSeconds, nil); err != nil {
+		t.Fatalf("unable to set polling bounds: %v", err)
+	}
+
+	info, err := s.KeyInfo(ctx, "key")
+	if err != nil {
+		t.Fatalf("unable to get key info: %v", err)
+	}
+	if info.LastFetchedAt == nil || !info.LastFetchedAt.Equal(fetchedAt) {
+		t.Fatalf("expected last fetched at %s, got %v", fetchedAt, info.LastFetchedAt)
+	}
+	if info.MinPollSeconds == nil || *info.MinPollSeconds != minSeconds || info.MaxPollSeconds != nil {
+		t.Fatalf("unexpected polling bounds %v %v", info.MinPollSeconds, info.MaxPollSeconds)
+	}
+
+	infos, err := s.KeyInfos(ctx)
+	if err != nil {
+		t.Fatalf("unable to get key infos: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 key info, got %d", len(infos))
+	}
+}
+
+func TestSQLiteRetention(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now().Truncate(time.Second)
+	for i := 0; i < 4; i++ {
+		l := newTestLocation(t, "key", now.Add(-time.Duration(i)*24*time.Hour), 47.37, 8.54)
+		l.OriginalContent = []byte{1, 2, 3}
+		if _, err := s.SaveLocation(ctx, l); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+	}
+
+	keyIDs, err := s.LocationKeyIDs(ctx)
+	if err != nil || len(keyIDs) != 1 || keyIDs[0] != "key" {
+		t.Fatalf("unexpected key ids %v: %v", keyIDs, err)
+	}
+
+	// Dry runs only count
+	n, err := s.DeleteLocationsBefore(ctx, now.Add(-36*time.Hour), true)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 locations to delete, got %d: %v", n, err)
+	}
+	if n, err := s.ClearOriginalContent(ctx, now, true); err != nil || n != 3 {
+		t.Fatalf("expected 3 payloads to clear, got %d: %v", n, err)
+	}
+	samples, err := s.LocationSamples(ctx, "key", time.Time{}, now)
+	if err != nil || len(samples) != 4 {
+		t.Fatalf("expected 4 samples, got %d: %v", len(samples), err)
+	}
+
+	if n, err := s.DeleteLocationsBefore(ctx, now.Add(-36*time.Hour), false); err != nil || n != 2 {
+		t.Fatalf("expected 2 deleted locations, got %d: %v", n, err)
+	}
+	if n, err := s.DeleteLocations(ctx, "key", []time.Time{samples[2].FoundAt}); err != nil || n != 1 {
+		t.Fatalf("expected 1 deleted location, got %d: %v", n, err)
+	}
+	if n, err := s.ClearOriginalContent(ctx, now.Add(time.Second), false); err != nil || n != 1 {
+		t.Fatalf("expected 1 cleared payload, got %d: %v", n, err)
+	}
+	last, err := s.LastLocation(ctx, "key", time.Time{})
+	if err != nil {
+		t.Fatalf("unable to get last location: %v", err)
+	}
+	if last.OriginalContent != nil {
+		t.Fatalf("expected the original content to be cleared")
+	}
+}
+
+func TestSQLiteLocationsWithin(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now().Truncate(time.Second)
+	// Zurich, Bern and again Zurich for another key
+	locations := []*models.Location{
+		newTestLocation(t, "a", now.Add(-time.Hour), 47.3779, 8.5403),
+		newTestLocation(t, "a", now.Add(-2*time.Hour), 46.9490, 7.4392),
+		newTestLocation(t, "b", now.Add(-3*time.Hour), 47.3700, 8.5450),
+	}
+	for _, l := range locations {
+		if _, err := s.SaveLocation(ctx, l); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    AreaQuery
+		want int
+	}{
+		{"bounding box", AreaQuery{BoundingBox: &BoundingBox{MinLat: 47.3, MinLng: 8.4, MaxLat: 47.5, MaxLng: 8.6}}, 2},
+		{"circle", AreaQuery{Circle: &Circle{Lat: 46.9480, Lng: 7.4474, Radius: 1000}}, 1},
+		{"keys", AreaQuery{KeyIDs: []string{"b"}, Circle: &Circle{Lat: 47.3779, Lng: 8.5403, Radius: 2000}}, 1},
+		{"limit", AreaQuery{Limit: 1, BoundingBox: &BoundingBox{MinLat: 46, MinLng: 7, MaxLat: 48, MaxLng: 9}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.q.From = now.Add(-24 * time.Hour)
+			tt.q.To = now
+			res, err := s.LocationsWithin(ctx, tt.q)
+			if err != nil {
+				t.Fatalf("unable to search locations: %v", err)
+			}
+			if len(res) != tt.want {
+				t.Fatalf("expected %d locations, got %d", tt.want, len(res))
+			}
+		})
+	}
+
+	if _, err := s.LocationsWithin(ctx, AreaQuery{From: now.Add(-time.Hour), To: now}); err == nil {
+		t.Fatalf("expected an error without an area")
+	}
+}
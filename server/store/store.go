@@ -0,0 +1,179 @@
+// Package store persists the locations and the key metadata
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "store")
+
+// ErrNotFound is returned when the requested record doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// Store persists the locations and the key metadata
+type Store interface {
+	// SaveLocation stores a location, ignoring it if it was already stored.
+	// It returns whether the location was stored.
+	SaveLocation(ctx context.Context, location *models.Location) (bool, error)
+	// LastLocation returns the most recent location of keyID found before
+	// before (or at any time if before is zero)
+	LastLocation(ctx context.Context, keyID string, before time.Time) (*models.Location, error)
+	// Locations returns the locations of keyID found between from and to, newest first
+	Locations(ctx context.Context, keyID string, from time.Time, to time.Time) ([]models.Location, error)
+
+	// LocationsCreatedAfter returns up to limit locations of keyIDs (all the
+	// keys if empty) with a geometry, stored after after, the most recently
+	// stored first
+	LocationsCreatedAfter(ctx context.Context, keyIDs []string, after time.Time, limit int) ([]models.Location, error)
+
+	// LocationsWithin returns the locations matching q, newest first
+	LocationsWithin(ctx context.Context, q AreaQuery) ([]models.Location, error)
+
+	// LocationKeyIDs returns the IDs of the keys with stored locations
+	LocationKeyIDs(ctx context.Context) ([]string, error)
+	// LocationSamples returns the locations of keyID found between from
+	// and to, without their content, oldest first
+	LocationSamples(ctx context.Context, keyID string, from time.Time, to time.Time) ([]LocationSample, error)
+	// DeleteLocations deletes the locations of keyID found at foundAt
+	DeleteLocations(ctx context.Context, keyID string, foundAt []time.Time) (int64, error)
+	// DeleteLocationsBefore deletes the locations found before before, or
+	// only counts them if dryRun is set
+	DeleteLocationsBefore(ctx context.Context, before time.Time, dryRun bool) (int64, error)
+	// ClearOriginalContent removes the original content of the locations
+	// found before before, or only counts them if dryRun is set
+	ClearOriginalContent(ctx context.Context, before time.Time, dryRun bool) (int64, error)
+
+	// KeyInfo returns the info of keyID
+	KeyInfo(ctx context.Context, keyID string) (*models.KeyInfo, error)
+	// KeyInfos returns the info of all the keys
+	KeyInfos(ctx context.Context) ([]models.KeyInfo, error)
+	// SetLastFetchedAt records the end of the last window fetched for keyID
+	SetLastFetchedAt(ctx context.Context, keyID string, t time.Time) error
+	// SetPollingBounds sets the polling bounds of keyID, nil to use the defaults
+	SetPollingBounds(ctx context.Context, keyID string, minSeconds *int, maxSeconds *int) error
+	// SetLostAt marks keyID as lost at lostAt, nil marks it as found
+	SetLostAt(ctx context.Context, keyID string, lostAt *time.Time) error
+	// SetTraccarEnabled enables or disables the forwarding of keyID to Traccar
+	SetTraccarEnabled(ctx context.Context, keyID string, enabled bool) error
+
+	// Geofences returns all the geofences
+	Geofences(ctx context.Context) ([]models.Geofence, error)
+	// Geofence returns the geofence with the given id
+	Geofence(ctx context.Context, id uint) (*models.Geofence, error)
+	// SaveGeofence creates the geofence, or updates it if its ID is set
+	SaveGeofence(ctx context.Context, geofence *models.Geofence) error
+	// DeleteGeofence deletes a geofence, its state and its events
+	DeleteGeofence(ctx context.Context, id uint) error
+	// GeofenceState returns whether keyID is in the geofence
+	GeofenceState(ctx context.Context, geofenceID uint, keyID string) (*models.GeofenceState, error)
+	// SaveGeofenceState creates or updates a geofence state
+	SaveGeofenceState(ctx context.Context, state *models.GeofenceState) error
+	// SaveGeofenceEvent stores a geofence event
+	SaveGeofenceEvent(ctx context.Context, event *models.GeofenceEvent) error
+	// GeofenceEvents returns the events matching q, newest first
+	GeofenceEvents(ctx context.Context, q GeofenceEventQuery) ([]models.GeofenceEvent, error)
+
+	// Webhooks returns all the webhooks
+	Webhooks(ctx context.Context) ([]models.Webhook, error)
+	// Webhook returns the webhook with the given id
+	Webhook(ctx context.Context, id uint) (*models.Webhook, error)
+	// SaveWebhook creates the webhook, or updates it if its ID is set
+	SaveWebhook(ctx context.Context, webhook *models.Webhook) error
+	// DeleteWebhook deletes a webhook and its deliveries
+	DeleteWebhook(ctx context.Context, id uint) error
+	// SaveDelivery creates the delivery, or updates it if its ID is set
+	SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// DueDeliveries returns up to limit pending deliveries to attempt before
+	// before, oldest first
+	DueDeliveries(ctx context.Context, before time.Time, limit int) ([]models.WebhookDelivery, error)
+	// Deliveries returns the deliveries matching q, newest first
+	Deliveries(ctx context.Context, q DeliveryQuery) ([]models.WebhookDelivery, error)
+
+	// EnqueueTraccar adds entries to the Traccar outbox, the locations
+	// already pending are ignored and the failed ones are queued again. It
+	// returns the number of entries added or requeued.
+	EnqueueTraccar(ctx context.Context, entries []models.TraccarOutbox) (int64, error)
+	// DueTraccar returns up to limit pending outbox entries to send before
+	// before, oldest location first
+	DueTraccar(ctx context.Context, before time.Time, limit int) ([]models.TraccarOutbox, error)
+	// SaveTraccar updates an outbox entry after a failed attempt
+	SaveTraccar(ctx context.Context, entry *models.TraccarOutbox) error
+	// DeleteTraccar removes a sent entry from the outbox
+	DeleteTraccar(ctx context.Context, id uint) error
+	// TraccarOutboxSize returns the number of entries in the outbox with status
+	TraccarOutboxSize(ctx context.Context, status string) (int64, error)
+
+	// KeyAliases returns the aliases of keyIDs
+	KeyAliases(ctx context.Context, keyIDs []string) ([]models.KeyAlias, error)
+
+	Close() error
+}
+
+// LocationSample identifies a stored location, see Store.LocationSamples
+type LocationSample struct {
+	FoundAt    time.Time
+	Confidence int
+}
+
+// GeofenceEventQuery filters the geofence events, zero values match everything
+type GeofenceEventQuery struct {
+	KeyID      string
+	GeofenceID uint
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+// DeliveryQuery filters the webhook deliveries, zero values match everything
+type DeliveryQuery struct {
+	WebhookID uint
+	Status    string
+	Limit     int
+}
+
+const sqlitePrefix = "sqlite://"
+
+// Open opens the store at dsn: a SQLite database if dsn starts with
+// sqlite:// (e.g. sqlite://searchparty.db), a PostgreSQL one otherwise.
+// If autoMigrate is set the pending migrations are applied, otherwise the
+// schema must already be up to date. A schema newer than the known
+// migrations is always refused.
+func Open(ctx context.Context, dsn string, autoMigrate bool) (Store, error) {
+	m, err := NewMigrator(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if autoMigrate {
+		err = m.Up(ctx)
+	} else {
+		err = m.check(ctx)
+	}
+	if err != nil {
+		_ = m.Close()
+		return nil, err
+	}
+	return &gormStore{db: m.db, dialect: m.dialect}, nil
+}
+
+// NewMigrator returns a Migrator for the database at dsn, see Open
+func NewMigrator(dsn string) (*Migrator, error) {
+	if path, ok := strings.CutPrefix(dsn, sqlitePrefix); ok {
+		db, err := openSQLite(path)
+		if err != nil {
+			return nil, err
+		}
+		return newMigrator(db, dialectSQLite)
+	}
+	db, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db, dialectPostgres)
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+func (s *gormStore) EnqueueTraccar(ctx context.Context, entries []models.TraccarOutbox) (int64, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	for i := range entries {
+		entries[i].FoundAt = entries[i].FoundAt.UTC()
+		entries[i].NextAttemptAt = entries[i].NextAttemptAt.UTC()
+		if entries[i].Status == "" {
+			entries[i].Status = models.TraccarPending
+		}
+	}
+	// The failed locations are queued again, the pending ones are left as is
+	tx := s.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key_id"}, {Name: "found_at"}},
+			DoUpdates: clause.AssignmentColumns([]string{"status", "attempts", "next_attempt_at", "last_error"}),
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Eq{Column: clause.Column{Table: "traccar_outbox", Name: "status"}, Value: models.TraccarFailed},
+			}},
+		}).
+		CreateInBatches(entries, deleteBatchSize)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("unable to enqueue traccar locations: %w", tx.Error)
+	}
+	return tx.RowsAffected, nil
+}
+
+func (s *gormStore) DueTraccar(ctx context.Context, before time.Time, limit int) ([]models.TraccarOutbox, error) {
+	var entries []models.TraccarOutbox
+	tx := s.db.
+		WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", models.TraccarPending, before.UTC()).
+		Order("found_at asc, id asc").
+		Limit(limit).
+		Find(&entries)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch traccar outbox: %w", tx.Error)
+	}
+	return entries, nil
+}
+
+func (s *gormStore) SaveTraccar(ctx context.Context, entry *models.TraccarOutbox) error {
+	tx := s.db.
+		WithContext(ctx).
+		Model(entry).
+		Updates(map[string]any{
+			"status":          entry.Status,
+			"attempts":        entry.Attempts,
+			"next_attempt_at": entry.NextAttemptAt.UTC(),
+			"last_error":      entry.LastError,
+		})
+	if tx.Error != nil {
+		return fmt.Errorf("unable to update traccar outbox: %w", tx.Error)
+	}
+	return nil
+}
+
+func (s *gormStore) DeleteTraccar(ctx context.Context, id uint) error {
+	if err := s.db.WithContext(ctx).Delete(&models.TraccarOutbox{}, id).Error; err != nil {
+		return fmt.Errorf("unable to delete traccar outbox entry: %w", err)
+	}
+	return nil
+}
+
+func (s *gormStore) TraccarOutboxSize(ctx context.Context, status string) (int64, error) {
+	var n int64
+	if err := s.db.WithContext(ctx).Model(&models.TraccarOutbox{}).Where("status = ?", status).Count(&n).Error; err != nil {
+		return 0, fmt.Errorf("unable to count traccar outbox: %w", err)
+	}
+	return n, nil
+}
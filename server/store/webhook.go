@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+func (s *gormStore) Webhooks(ctx context.Context) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	tx := s.db.
+		WithContext(ctx).
+		Order("id asc").
+		Find(&webhooks)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch webhooks: %w", tx.Error)
+	}
+	return webhooks, nil
+}
+
+func (s *gormStore) Webhook(ctx context.Context, id uint) (*models.Webhook, error) {
+	var webhook models.Webhook
+	tx := s.db.
+		WithContext(ctx).
+		First(&webhook, id)
+	if tx.Error != nil {
+		return nil, notFound(tx.Error, "unable to fetch webhook")
+	}
+	return &webhook, nil
+}
+
+func (s *gormStore) SaveWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.ID == 0 {
+		if err := s.db.WithContext(ctx).Create(webhook).Error; err != nil {
+			return fmt.Errorf("unable to create webhook: %w", err)
+		}
+		return nil
+	}
+	tx := s.db.
+		WithContext(ctx).
+		Model(webhook).
+		Select("*").
+		Omit("created_at").
+		Updates(webhook)
+	if tx.Error != nil {
+		return fmt.Errorf("unable to update webhook: %w", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *gormStore) DeleteWebhook(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return fmt.Errorf("unable to delete webhook deliveries: %w", err)
+		}
+		res := tx.Delete(&models.Webhook{}, id)
+		if res.Error != nil {
+			return fmt.Errorf("unable to delete webhook: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *gormStore) SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+	if delivery.DeliveredAt != nil {
+		t := delivery.DeliveredAt.UTC()
+		delivery.DeliveredAt = &t
+	}
+	if err := s.db.WithContext(ctx).Save(delivery).Error; err != nil {
+		return fmt.Errorf("unable to save webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *gormStore) DueDeliveries(ctx context.Context, before time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	tx := s.db.
+		WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, before.UTC()).
+		Order("next_attempt_at asc, id asc").
+		Limit(limit).
+		Find(&deliveries)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("unable to fetch due deliveries: %w", tx.Error)
+	}
+	return deliveries, nil
+}
+
+func (s *gormStore) Deliveries(ctx context.Context, q DeliveryQuery) ([]models.WebhookDelivery, error) {
+	db := s.db.
+		WithContext(ctx).
+		Order("id desc")
+	if q.WebhookID != 0 {
+		db = db.Where("webhook_id = ?", q.WebhookID)
+	}
+	if q.Status != "" {
+		db = db.Where("status = ?", q.Status)
+	}
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit)
+	}
+	var deliveries []models.WebhookDelivery
+	if err := db.Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("unable to fetch webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go/server/events"
+)
+
+// keepAliveInterval is how often a comment is sent on idle streams, to
+// keep the proxies from closing them
+const keepAliveInterval = 15 * time.Second
+
+// SetEvents sets the broker of the live streams
+func (s *Server) SetEvents(b *events.Broker) {
+	s.events = b
+}
+
+// keyChanged publishes the current status of keyID to the live streams
+func (s *Server) keyChanged(ctx context.Context, keyID string) {
+	if s.events == nil {
+		return
+	}
+	info, err := s.store.KeyInfo(ctx, keyID)
+	if err != nil {
+		logger.Warnf("unable to get key info of %s: %v", keyID, err)
+		return
+	}
+	s.events.KeyChanged(info)
+}
+
+// streamEvents streams the new locations and key status changes as
+// Server-Sent Events. The keys can be selected with the (repeatable) key
+// parameter. The stream resumes after the location with the id given in
+// the Last-Event-ID header or the since parameter, the RFC3339 time the
+// location was stored at.
+func (s *Server) streamEvents(c *gin.Context) {
+	if s.events == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "live streams not enabled"})
+		return
+	}
+	var keyIDs []string
+	for _, k := range c.QueryArray("key") {
+		keyID := dirtyKeyID(k)
+		if _, ok := s.keyMap[keyID]; !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("key %q not found", k)})
+			return
+		}
+		keyIDs = append(keyIDs, keyID)
+	}
+	var since time.Time
+	sinceStr := c.GetHeader("Last-Event-ID")
+	if sinceStr == "" {
+		sinceStr = c.Query("since")
+	}
+	if sinceStr != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, sinceStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a RFC3339 timestamp"})
+			return
+		}
+	}
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	// The events and the keep-alives are written from two goroutines
+	var mu sync.Mutex
+	write := func(f func() error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := f(); err != nil {
+			return err
+		}
+		w.Flush()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := write(func() error {
+					_, err := fmt.Fprint(w, ": keep-alive\n\n")
+					return err
+				})
+				if err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err := events.Stream(ctx, s.store, s.events, keyIDs, since, func(e events.Event) error {
+		return write(func() error { return writeEvent(w, e) })
+	})
+	cancel()
+	wg.Wait()
+	if err != nil {
+		logger.Debugf("event stream closed: %v", err)
+		_ = write(func() error {
+			_, err := fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+			return err
+		})
+	}
+}
+
+// writeEvent writes e as a Server-Sent Event named after its type. The
+// locations' id is their cursor, to resume after them.
+func writeEvent(w io.Writer, e events.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if e.Type == events.TypeLocation && !e.Cursor.IsZero() {
+		if _, err := fmt.Fprintf(w, "id: %s\n", e.Cursor.UTC().Format(time.RFC3339Nano)); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+	return err
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/traccar"
+)
+
+// SetTraccar sets the forwarder replaying the locations to Traccar
+func (s *Server) SetTraccar(f *traccar.Forwarder) {
+	s.forwarder = f
+}
+
+type traccarRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// setTraccar enables or disables the forwarding of a key to Traccar
+func (s *Server) setTraccar(c *gin.Context) {
+	keyID := dirtyKeyID(c.Param("keyId"))
+	if _, ok := s.keyMap[keyID]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	var req traccarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.store.SetTraccarEnabled(c.Request.Context(), keyID, req.Enabled); err != nil {
+		logger.Errorf("unable to save traccar forwarding: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to save traccar forwarding"})
+		return
+	}
+	s.keyChanged(c.Request.Context(), keyID)
+	c.JSON(http.StatusOK, models.KeyInfo{ID: keyID, TraccarEnabled: req.Enabled})
+}
+
+// replayTraccar queues the stored locations of a key found between from and
+// to (RFC3339, the whole history by default) for Traccar
+func (s *Server) replayTraccar(c *gin.Context) {
+	if s.forwarder == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "traccar forwarding not enabled"})
+		return
+	}
+	keyID := dirtyKeyID(c.Param("keyId"))
+	if _, ok := s.keyMap[keyID]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	from, to, err := parseHistoryInterval(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	n, err := s.forwarder.Replay(c.Request.Context(), keyID, from, to)
+	if err != nil {
+		logger.Errorf("unable to replay locations to traccar: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to replay locations"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"queued": n})
+}
+
+// getTraccar returns the number of pending and failed locations in the
+// Traccar outbox
+func (s *Server) getTraccar(c *gin.Context) {
+	if s.forwarder == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "traccar forwarding not enabled"})
+		return
+	}
+	sizes := gin.H{}
+	for _, status := range []string{models.TraccarPending, models.TraccarFailed} {
+		n, err := s.store.TraccarOutboxSize(c.Request.Context(), status)
+		if err != nil {
+			logger.Errorf("unable to get traccar outbox: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to get traccar outbox"})
+			return
+		}
+		sizes[status] = n
+	}
+	c.JSON(http.StatusOK, sizes)
+}
@@ -0,0 +1,298 @@
+// Package traccar forwards the locations of the keys to a Traccar server
+// with the OsmAnd protocol, through a persistent outbox
+package traccar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "traccar")
+
+// Device id sources
+const (
+	// IDKey identifies the devices with their cleaned key id
+	IDKey = "key"
+	// IDAlias identifies the devices with their alias, or their key id
+	IDAlias = "alias"
+)
+
+const (
+	// minBackoff is the delay before retrying a failed location, doubled on
+	// every attempt
+	minBackoff = 10 * time.Second
+	// maxBackoff caps the delay between two attempts
+	maxBackoff = 10 * time.Minute
+	// pollInterval is how often the outbox is checked
+	pollInterval = 10 * time.Second
+	// batchSize is the number of locations sent per outbox check
+	batchSize = 100
+	// requestTimeout bounds a request to Traccar
+	requestTimeout = 10 * time.Second
+	// defaultMaxAttempts is the number of attempts before a location fails
+	defaultMaxAttempts = 8
+)
+
+// Config describes the Traccar server
+type Config struct {
+	// URL is the OsmAnd endpoint of Traccar, e.g. http://localhost:5055
+	URL string
+	// IDSource is IDKey or IDAlias
+	IDSource string
+}
+
+// Forwarder queues the new locations of the enabled keys and sends them to
+// Traccar. It implements store.LocationHook.
+type Forwarder struct {
+	cfg    Config
+	st     store.Store
+	client *http.Client
+
+	// MaxAttempts is the number of attempts before a location is marked as
+	// failed
+	MaxAttempts int
+
+	wakeup chan struct{}
+	now    func() time.Time
+}
+
+// New returns a Forwarder, Run must be called to send the queued locations
+func New(cfg Config, st store.Store) (*Forwarder, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("traccar url must be an http or https URL")
+	}
+	switch cfg.IDSource {
+	case "":
+		cfg.IDSource = IDKey
+	case IDKey, IDAlias:
+	default:
+		return nil, fmt.Errorf("unknown traccar id source %q, expected %s or %s", cfg.IDSource, IDKey, IDAlias)
+	}
+	return &Forwarder{
+		cfg:    cfg,
+		st:     st,
+		client: &http.Client{Timeout: requestTimeout},
+
+		MaxAttempts: defaultMaxAttempts,
+
+		wakeup: make(chan struct{}, 1),
+		now:    time.Now,
+	}, nil
+}
+
+// LocationSaved queues location if its key is enabled, errors are only logged
+func (f *Forwarder) LocationSaved(ctx context.Context, location *models.Location) {
+	info, err := f.st.KeyInfo(ctx, location.KeyID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return
+	case err != nil:
+		logger.Errorf("unable to get key info of %s: %v", location.KeyID, err)
+		return
+	case !info.TraccarEnabled:
+		return
+	}
+	if _, err := f.enqueue(ctx, []models.Location{*location}); err != nil {
+		logger.Errorf("unable to queue location of %s: %v", location.KeyID, err)
+	}
+}
+
+// Replay queues the stored locations of keyID found between from and to,
+// whether the key is enabled or not. It returns the number of locations
+// queued, the ones already pending aren't queued again and the failed ones
+// are retried.
+func (f *Forwarder) Replay(ctx context.Context, keyID string, from time.Time, to time.Time) (int64, error) {
+	locations, err := f.st.Locations(ctx, keyID, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return f.enqueue(ctx, locations)
+}
+
+func (f *Forwarder) enqueue(ctx context.Context, locations []models.Location) (int64, error) {
+	now := f.now()
+	entries := make([]models.TraccarOutbox, 0, len(locations))
+	for _, l := range locations {
+		if l.Geometry == nil {
+			continue
+		}
+		entries = append(entries, models.TraccarOutbox{
+			KeyID:         l.KeyID,
+			FoundAt:       l.FoundAt,
+			Lat:           l.Geometry.Coords().Y(),
+			Lng:           l.Geometry.Coords().X(),
+			Accuracy:      l.Accuracy(),
+			Battery:       l.DecodedStatus().Battery().Percent(),
+			NextAttemptAt: now,
+		})
+	}
+	n, err := f.st.EnqueueTraccar(ctx, entries)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		select {
+		case f.wakeup <- struct{}{}:
+		default:
+		}
+	}
+	return n, nil
+}
+
+// Run sends the queued locations until ctx is done
+func (f *Forwarder) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := f.Flush(ctx); err != nil {
+			logger.Errorf("unable to forward locations: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-f.wakeup:
+		}
+	}
+}
+
+// Flush sends the due locations of the outbox. The locations Traccar rejects
+// or that failed MaxAttempts times are marked as failed, otherwise it stops
+// at the first failure, Traccar is likely down.
+func (f *Forwarder) Flush(ctx context.Context) error {
+	aliases := map[string]string{}
+	for {
+		entries, err := f.st.DueTraccar(ctx, f.now(), batchSize)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		if f.cfg.IDSource == IDAlias {
+			if err := f.loadAliases(ctx, entries, aliases); err != nil {
+				return err
+			}
+		}
+		for i := range entries {
+			e := &entries[i]
+			err := f.send(ctx, e, aliases[e.KeyID])
+			if err != nil {
+				e.Attempts++
+				e.LastError = err.Error()
+				var statusErr *statusError
+				if (errors.As(err, &statusErr) && statusErr.permanent()) || e.Attempts >= f.MaxAttempts {
+					e.Status = models.TraccarFailed
+					logger.Warnf("unable to forward location of %s after %d attempts: %v", e.KeyID, e.Attempts, err)
+					if err := f.st.SaveTraccar(ctx, e); err != nil {
+						return err
+					}
+					continue
+				}
+				e.NextAttemptAt = f.now().Add(backoff(e.Attempts))
+				logger.Debugf("unable to forward location of %s, retrying at %s: %v", e.KeyID, e.NextAttemptAt, err)
+				return f.st.SaveTraccar(ctx, e)
+			}
+			if err := f.st.DeleteTraccar(ctx, e.ID); err != nil {
+				return err
+			}
+		}
+		if len(entries) < batchSize {
+			return nil
+		}
+	}
+}
+
+// loadAliases adds the aliases of the keys of entries missing from aliases
+func (f *Forwarder) loadAliases(ctx context.Context, entries []models.TraccarOutbox, aliases map[string]string) error {
+	var keyIDs []string
+	for _, e := range entries {
+		if _, ok := aliases[e.KeyID]; !ok {
+			aliases[e.KeyID] = ""
+			keyIDs = append(keyIDs, e.KeyID)
+		}
+	}
+	keyAliases, err := f.st.KeyAliases(ctx, keyIDs)
+	if err != nil {
+		return err
+	}
+	for _, a := range keyAliases {
+		aliases[a.KeyID] = a.Alias
+	}
+	return nil
+}
+
+// DeviceID returns the Traccar device id of keyID
+func (f *Forwarder) DeviceID(keyID string, alias string) string {
+	if f.cfg.IDSource == IDAlias && alias != "" {
+		return alias
+	}
+	// Replaces / with another non-base64 character, as the REST API does
+	return strings.ReplaceAll(keyID, "/", "-")
+}
+
+// send posts e with the OsmAnd protocol
+func (f *Forwarder) send(ctx context.Context, e *models.TraccarOutbox, alias string) error {
+	q := url.Values{}
+	q.Set("id", f.DeviceID(e.KeyID, alias))
+	q.Set("lat", strconv.FormatFloat(e.Lat, 'f', -1, 64))
+	q.Set("lon", strconv.FormatFloat(e.Lng, 'f', -1, 64))
+	q.Set("timestamp", strconv.FormatInt(e.FoundAt.Unix(), 10))
+	q.Set("accuracy", strconv.Itoa(e.Accuracy))
+	q.Set("batt", strconv.Itoa(e.Battery))
+
+	u, err := url.Parse(f.cfg.URL)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &statusError{code: res.StatusCode}
+	}
+	return nil
+}
+
+// statusError is a non 2xx response of Traccar
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.code)
+}
+
+// permanent returns whether retrying the request can't succeed, e.g. for an
+// unknown device
+func (e *statusError) permanent() bool {
+	return e.code >= 400 && e.code < 500 &&
+		e.code != http.StatusRequestTimeout && e.code != http.StatusTooManyRequests
+}
+
+// backoff returns the delay before the attempt following attempts
+func backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
@@ -0,0 +1,193 @@
+package traccar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func newLocation(t *testing.T, keyID string, foundAt time.Time) *models.Location {
+	t.Helper()
+	p, err := geom.NewPoint(geom.XY).SetSRID(4326).SetCoords(geom.Coord{8.54, 47.37})
+	if err != nil {
+		t.Fatalf("unable to create point: %v", err)
+	}
+	g := models.GeomPoint(*p)
+	return &models.Location{FoundAt: foundAt, KeyID: keyID, Geometry: &g, Confidence: 20}
+}
+
+func TestForwarder(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	var mu sync.Mutex
+	down := true
+	var received []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received = append(received, r.URL.Query())
+	}))
+	defer srv.Close()
+
+	f, err := New(Config{URL: srv.URL}, st)
+	if err != nil {
+		t.Fatalf("unable to create forwarder: %v", err)
+	}
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	if err := st.SetTraccarEnabled(ctx, "a/b", true); err != nil {
+		t.Fatalf("unable to enable key: %v", err)
+	}
+	foundAt := now.Add(-time.Hour).Truncate(time.Second)
+	for _, keyID := range []string{"a/b", "disabled"} {
+		l := newLocation(t, keyID, foundAt)
+		if _, err := st.SaveLocation(ctx, l); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+		f.LocationSaved(ctx, l)
+	}
+
+	// Traccar is down, the location stays in the outbox
+	if err := f.Flush(ctx); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+	if n, err := st.TraccarOutboxSize(ctx, models.TraccarPending); err != nil || n != 1 {
+		t.Fatalf("expected a queued location, got %d %v", n, err)
+	}
+
+	// Replaying doesn't queue the same location twice
+	if n, err := f.Replay(ctx, "a/b", time.Time{}, now); err != nil || n != 0 {
+		t.Fatalf("expected nothing to replay, got %d %v", n, err)
+	}
+	if n, err := f.Replay(ctx, "disabled", time.Time{}, now); err != nil || n != 1 {
+		t.Fatalf("expected a replayed location, got %d %v", n, err)
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	// The failed location waits for its backoff
+	if err := f.Flush(ctx); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+	if n, _ := st.TraccarOutboxSize(ctx, models.TraccarPending); n != 1 {
+		t.Fatalf("expected the failed location to wait for its backoff, got %d entries", n)
+	}
+	now = now.Add(minBackoff)
+	if err := f.Flush(ctx); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+	if n, _ := st.TraccarOutboxSize(ctx, models.TraccarPending); n != 0 {
+		t.Fatalf("expected an empty outbox, got %d entries", n)
+	}
+	if len(received) != 2 || received[0].Get("id") != "disabled" {
+		t.Fatalf("unexpected locations %v", received)
+	}
+	q := received[1]
+	if q.Get("id") != "a-b" || q.Get("lat") != "47.37" || q.Get("lon") != "8.54" || q.Get("accuracy") != "20" ||
+		q.Get("batt") != "100" || q.Get("timestamp") != strconv.FormatInt(foundAt.Unix(), 10) {
+		t.Fatalf("unexpected query %v", q)
+	}
+}
+
+func TestForwarderFailed(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		id := r.URL.Query().Get("id")
+		requests[id]++
+		switch id {
+		case "unknown":
+			w.WriteHeader(http.StatusBadRequest)
+		case "flaky":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	f, err := New(Config{URL: srv.URL}, st)
+	if err != nil {
+		t.Fatalf("unable to create forwarder: %v", err)
+	}
+	f.MaxAttempts = 2
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	foundAt := now.Add(-time.Hour).Truncate(time.Second)
+	for i, keyID := range []string{"unknown", "flaky", "known"} {
+		l := newLocation(t, keyID, foundAt.Add(time.Duration(i)*time.Second))
+		if _, err := st.SaveLocation(ctx, l); err != nil {
+			t.Fatalf("unable to save location: %v", err)
+		}
+		if _, err := f.Replay(ctx, keyID, time.Time{}, now); err != nil {
+			t.Fatalf("unable to replay: %v", err)
+		}
+	}
+
+	// The rejected location doesn't block the following ones
+	if err := f.Flush(ctx); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+	if n, _ := st.TraccarOutboxSize(ctx, models.TraccarFailed); n != 1 {
+		t.Fatalf("expected the rejected location to fail, got %d failed", n)
+	}
+	if n, _ := st.TraccarOutboxSize(ctx, models.TraccarPending); n != 2 {
+		t.Fatalf("expected 2 pending locations, got %d", n)
+	}
+
+	// The flaky location fails after MaxAttempts, the rejected one isn't retried
+	now = now.Add(minBackoff)
+	if err := f.Flush(ctx); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+	if n, _ := st.TraccarOutboxSize(ctx, models.TraccarFailed); n != 2 {
+		t.Fatalf("expected 2 failed locations, got %d", n)
+	}
+	if n, _ := st.TraccarOutboxSize(ctx, models.TraccarPending); n != 0 {
+		t.Fatalf("expected an empty outbox, got %d pending", n)
+	}
+	mu.Lock()
+	if requests["unknown"] != 1 || requests["flaky"] != 2 || requests["known"] != 1 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	mu.Unlock()
+
+	// Replaying queues the failed location again
+	if n, err := f.Replay(ctx, "flaky", time.Time{}, now); err != nil || n != 1 {
+		t.Fatalf("expected a replayed location, got %d %v", n, err)
+	}
+	entries, err := st.DueTraccar(ctx, now, batchSize)
+	if err != nil || len(entries) != 1 || entries[0].Attempts != 0 || entries[0].Status != models.TraccarPending {
+		t.Fatalf("expected a pending location, got %+v %v", entries, err)
+	}
+}
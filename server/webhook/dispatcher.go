@@ -0,0 +1,262 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+var logger = logrus.StandardLogger().WithField("pkg", "webhook")
+
+const (
+	// defaultMaxAttempts is the number of attempts before a delivery fails
+	defaultMaxAttempts = 8
+	// minBackoff is the delay before the first retry, doubled on every attempt
+	minBackoff = 30 * time.Second
+	// maxBackoff caps the delay between two attempts
+	maxBackoff = time.Hour
+	// pollInterval is how often the queue is checked for retries
+	pollInterval = 5 * time.Second
+	// batchSize is the number of deliveries attempted per queue check
+	batchSize = 50
+	// requestTimeout bounds a delivery attempt
+	requestTimeout = 10 * time.Second
+)
+
+// Dispatcher queues the events of the keys for the webhooks subscribed to
+// them and delivers them. It implements store.LocationHook.
+type Dispatcher struct {
+	st     store.Store
+	client *http.Client
+	// MaxAttempts is the number of attempts before a delivery is marked as failed
+	MaxAttempts int
+
+	wakeup chan struct{}
+	now    func() time.Time
+}
+
+// New returns a Dispatcher using the webhooks and the delivery queue of st
+func New(st store.Store) *Dispatcher {
+	return &Dispatcher{
+		st:          st,
+		client:      &http.Client{Timeout: requestTimeout},
+		MaxAttempts: defaultMaxAttempts,
+		wakeup:      make(chan struct{}, 1),
+		now:         time.Now,
+	}
+}
+
+// Publish queues a payload for every webhook subscribed to event for keyID
+func (d *Dispatcher) Publish(ctx context.Context, event string, keyID string, data any) error {
+	webhooks, err := d.st.Webhooks(ctx)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	queued := false
+	for i := range webhooks {
+		w := &webhooks[i]
+		if !matches(w, event, keyID) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(Payload{Event: event, KeyID: keyID, CreatedAt: d.now().UTC(), Data: data})
+			if err != nil {
+				return fmt.Errorf("unable to encode %s payload: %w", event, err)
+			}
+		}
+		if err := d.enqueue(ctx, w.ID, event, body); err != nil {
+			return err
+		}
+		queued = true
+	}
+	if queued {
+		d.wake()
+	}
+	return nil
+}
+
+func (d *Dispatcher) enqueue(ctx context.Context, webhookID uint, event string, body []byte) error {
+	return d.st.SaveDelivery(ctx, &models.WebhookDelivery{
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       string(body),
+		Status:        models.DeliveryPending,
+		NextAttemptAt: d.now(),
+	})
+}
+
+// Ping queues a ping for the webhook id, whatever its subscriptions
+func (d *Dispatcher) Ping(ctx context.Context, id uint) error {
+	w, err := d.st.Webhook(ctx, id)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(Payload{Event: EventPing, CreatedAt: d.now().UTC(), Data: struct{}{}})
+	if err != nil {
+		return err
+	}
+	if err := d.enqueue(ctx, w.ID, EventPing, body); err != nil {
+		return err
+	}
+	d.wake()
+	return nil
+}
+
+// LocationSaved publishes an EventLocation, errors are only logged
+func (d *Dispatcher) LocationSaved(ctx context.Context, location *models.Location) {
+	if location.Geometry == nil {
+		return
+	}
+	data := models.NewLocationResult(location)
+	if err := d.Publish(ctx, EventLocation, location.KeyID, data); err != nil {
+		logger.Errorf("unable to publish location of %s: %v", location.KeyID, err)
+	}
+}
+
+// GeofenceEvent publishes an EventGeofence, errors are only logged
+func (d *Dispatcher) GeofenceEvent(ctx context.Context, geofence *models.Geofence, event *models.GeofenceEvent) {
+	if err := d.Publish(ctx, EventGeofence, event.KeyID, GeofenceData{Geofence: geofence, Event: event}); err != nil {
+		logger.Errorf("unable to publish geofence event of %s: %v", event.KeyID, err)
+	}
+}
+
+// KeyLost publishes an EventKeyLost, errors are only logged
+func (d *Dispatcher) KeyLost(ctx context.Context, keyID string, lostAt time.Time) {
+	if err := d.Publish(ctx, EventKeyLost, keyID, KeyLostData{LostAt: lostAt}); err != nil {
+		logger.Errorf("unable to publish lost key %s: %v", keyID, err)
+	}
+}
+
+// BatteryLow publishes an EventBatteryLow, errors are only logged
+func (d *Dispatcher) BatteryLow(ctx context.Context, location *models.Location, previous *searchparty.BatteryLevel) {
+	data := BatteryLowData{
+		Battery:  location.DecodedStatus().Battery(),
+		Previous: previous,
+		Location: models.NewLocationResult(location),
+	}
+	if err := d.Publish(ctx, EventBatteryLow, location.KeyID, data); err != nil {
+		logger.Errorf("unable to publish low battery of %s: %v", location.KeyID, err)
+	}
+}
+
+func (d *Dispatcher) wake() {
+	select {
+	case d.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers the queued payloads until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.Deliver(ctx); err != nil {
+			logger.Errorf("unable to deliver webhooks: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wakeup:
+		}
+	}
+}
+
+// Deliver attempts the due deliveries once
+func (d *Dispatcher) Deliver(ctx context.Context) error {
+	for {
+		deliveries, err := d.st.DueDeliveries(ctx, d.now(), batchSize)
+		if err != nil {
+			return err
+		}
+		for i := range deliveries {
+			if err := d.attempt(ctx, &deliveries[i]); err != nil {
+				return err
+			}
+		}
+		if len(deliveries) < batchSize {
+			return nil
+		}
+	}
+}
+
+// attempt posts the payload of delivery and records the outcome, the
+// returned error is about the queue, not the delivery
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	w, err := d.st.Webhook(ctx, delivery.WebhookID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = "webhook deleted"
+		return d.st.SaveDelivery(ctx, delivery)
+	case err != nil:
+		return err
+	}
+
+	delivery.Attempts++
+	code, err := d.post(ctx, w, delivery)
+	delivery.ResponseCode = code
+	now := d.now()
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = err.Error()
+		logger.Warnf("delivery %d to webhook %d failed after %d attempts: %v", delivery.ID, w.ID, delivery.Attempts, err)
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+		logger.Debugf("delivery %d to webhook %d failed, retrying at %s: %v", delivery.ID, w.ID, delivery.NextAttemptAt, err)
+	}
+	return d.st.SaveDelivery(ctx, delivery)
+}
+
+// post sends the payload of delivery to w, any non 2xx response is an error
+func (d *Dispatcher) post(ctx context.Context, w *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "searchparty-go")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderSignature, Sign(w.Secret, body))
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// backoff returns the delay before the attempt following attempts
+func backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
@@ -0,0 +1,109 @@
+// Package webhook delivers signed JSON notifications of the keys' events to
+// the configured webhooks, retrying the failed deliveries
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/denysvitali/searchparty-go"
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+// Events sent to the webhooks
+const (
+	// EventLocation is sent for every new location of a key
+	EventLocation = "location"
+	// EventGeofence is sent when a key enters, leaves or dwells in a geofence
+	EventGeofence = "geofence"
+	// EventKeyLost is sent when a key is marked as lost
+	EventKeyLost = "key.lost"
+	// EventBatteryLow is sent when the battery of a key drops to low or critical
+	EventBatteryLow = "battery.low"
+	// EventPing is sent on demand to test a webhook
+	EventPing = "ping"
+)
+
+// Events lists the events a webhook can subscribe to
+var Events = []string{EventLocation, EventGeofence, EventKeyLost, EventBatteryLow, EventPing}
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Searchparty-Event"
+	HeaderDelivery  = "X-Searchparty-Delivery"
+	HeaderSignature = "X-Searchparty-Signature"
+)
+
+// Payload is the JSON body posted to the webhooks
+type Payload struct {
+	Event     string    `json:"event"`
+	KeyID     string    `json:"keyId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// GeofenceData is the data of an EventGeofence payload
+type GeofenceData struct {
+	Geofence *models.Geofence      `json:"geofence"`
+	Event    *models.GeofenceEvent `json:"event"`
+}
+
+// KeyLostData is the data of an EventKeyLost payload
+type KeyLostData struct {
+	LostAt time.Time `json:"lostAt"`
+}
+
+// BatteryLowData is the data of an EventBatteryLow payload. Previous is
+// omitted for the first location of the key.
+type BatteryLowData struct {
+	Battery  searchparty.BatteryLevel  `json:"battery"`
+	Previous *searchparty.BatteryLevel `json:"previous,omitempty"`
+	Location models.LocationResult     `json:"location"`
+}
+
+// Sign returns the signature of body sent in HeaderSignature: the hex
+// encoded HMAC-SHA256 of body keyed with secret, prefixed with "sha256="
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns whether signature is the signature of body
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Validate checks the URL and the events of w
+func Validate(w *models.Webhook) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	if w.Secret == "" {
+		return errors.New("secret is required")
+	}
+	for _, e := range w.Events {
+		if !slices.Contains(Events, e) {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	return nil
+}
+
+// matches returns whether w subscribed to event for keyID
+func matches(w *models.Webhook, event string, keyID string) bool {
+	if w.Disabled {
+		return false
+	}
+	if w.KeyID != nil && keyID != "" && *w.KeyID != keyID {
+		return false
+	}
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		8:  time.Hour,
+		20: time.Hour,
+	}
+	for attempts, want := range tests {
+		if got := backoff(attempts); got != want {
+			t.Fatalf("backoff(%d): expected %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	key := "key"
+	w := &models.Webhook{Events: []string{EventGeofence}, KeyID: &key}
+	if !matches(w, EventGeofence, "key") {
+		t.Fatalf("expected the webhook to match")
+	}
+	if matches(w, EventLocation, "key") || matches(w, EventGeofence, "other") {
+		t.Fatalf("expected the webhook to be filtered")
+	}
+	w.Disabled = true
+	if matches(w, EventGeofence, "key") {
+		t.Fatalf("expected a disabled webhook not to match")
+	}
+}
+
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	events   []string
+	err      string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	if !Verify("secret", body, req.Header.Get(HeaderSignature)) {
+		r.err = "invalid signature"
+	}
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil || p.Event != req.Header.Get(HeaderEvent) {
+		r.err = "invalid payload"
+	}
+	r.events = append(r.events, p.Event+" "+p.KeyID)
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "searchparty.db"), true)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer st.Close()
+
+	r := &receiver{failures: 2}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	key := "key"
+	for _, w := range []*models.Webhook{
+		{URL: srv.URL, Secret: "secret", KeyID: &key},
+		{URL: srv.URL, Secret: "secret", Events: []string{EventGeofence}},
+	} {
+		if err := Validate(w); err != nil {
+			t.Fatalf("invalid webhook: %v", err)
+		}
+		if err := st.SaveWebhook(ctx, w); err != nil {
+			t.Fatalf("unable to save webhook: %v", err)
+		}
+	}
+
+	now := time.Now()
+	d := New(st)
+	d.MaxAttempts = 3
+	d.now = func() time.Time { return now }
+
+	for _, keyID := range []string{"key", "other"} {
+		if err := d.Publish(ctx, EventKeyLost, keyID, KeyLostData{LostAt: now}); err != nil {
+			t.Fatalf("unable to publish: %v", err)
+		}
+	}
+	deliveries, err := st.Deliveries(ctx, store.DeliveryQuery{Status: models.DeliveryPending})
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("expected a single pending delivery, got %v %v", deliveries, err)
+	}
+
+	// The first attempt fails, the retry waits for the backoff
+	for _, advance := range []time.Duration{0, time.Second, backoff(1)} {
+		now = now.Add(advance)
+		if err := d.Deliver(ctx); err != nil {
+			t.Fatalf("unable to deliver: %v", err)
+		}
+	}
+	deliveries, err = st.Deliveries(ctx, store.DeliveryQuery{})
+	if err != nil {
+		t.Fatalf("unable to get deliveries: %v", err)
+	}
+	if deliveries[0].Status != models.DeliveryPending || deliveries[0].Attempts != 2 || deliveries[0].ResponseCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected delivery %+v", deliveries[0])
+	}
+
+	now = now.Add(backoff(2))
+	if err := d.Deliver(ctx); err != nil {
+		t.Fatalf("unable to deliver: %v", err)
+	}
+	deliveries, err = st.Deliveries(ctx, store.DeliveryQuery{})
+	if err != nil {
+		t.Fatalf("unable to get deliveries: %v", err)
+	}
+	if deliveries[0].Status != models.DeliveryDelivered || deliveries[0].Attempts != 3 || deliveries[0].DeliveredAt == nil {
+		t.Fatalf("unexpected delivery %+v", deliveries[0])
+	}
+	if r.err != "" || len(r.events) != 1 || r.events[0] != "key.lost key" {
+		t.Fatalf("unexpected events %v (%s)", r.events, r.err)
+	}
+
+	// Deliveries that keep failing are given up
+	r.failures = 10
+	if err := d.Publish(ctx, EventGeofence, "other", GeofenceData{}); err != nil {
+		t.Fatalf("unable to publish: %v", err)
+	}
+	for i := 0; i < d.MaxAttempts; i++ {
+		if err := d.Deliver(ctx); err != nil {
+			t.Fatalf("unable to deliver: %v", err)
+		}
+		now = now.Add(maxBackoff)
+	}
+	failed, err := st.Deliveries(ctx, store.DeliveryQuery{Status: models.DeliveryFailed})
+	if err != nil || len(failed) != 1 || failed[0].Attempts != d.MaxAttempts {
+		t.Fatalf("expected a failed delivery, got %+v %v", failed, err)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
+	"github.com/denysvitali/searchparty-go/server/webhook"
+)
+
+// maxDeliveries caps the number of deliveries returned at once
+const maxDeliveries = 1000
+
+// SetWebhooks sets the dispatcher used to ping the webhooks
+func (s *Server) SetWebhooks(d *webhook.Dispatcher) {
+	s.webhooks = d
+}
+
+func (s *Server) getWebhooks(c *gin.Context) {
+	webhooks, err := s.store.Webhooks(c.Request.Context())
+	if err != nil {
+		logger.Errorf("unable to get webhooks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to get webhooks"})
+		return
+	}
+	res := make([]models.Webhook, 0, len(webhooks))
+	for _, w := range webhooks {
+		w.Secret = ""
+		res = append(res, w)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) getWebhook(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+	w, err := s.store.Webhook(c.Request.Context(), id)
+	if err != nil {
+		s.webhookError(c, err)
+		return
+	}
+	w.Secret = ""
+	c.JSON(http.StatusOK, w)
+}
+
+// saveWebhook creates a webhook, or replaces it when called with an id. The
+// secret of a replaced webhook is kept if none is given.
+func (s *Server) saveWebhook(c *gin.Context) {
+	var w models.Webhook
+	if err := c.ShouldBindJSON(&w); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	status := http.StatusCreated
+	w.ID = 0
+	if c.Param("id") != "" {
+		id, ok := webhookID(c)
+		if !ok {
+			return
+		}
+		existing, err := s.store.Webhook(ctx, id)
+		if err != nil {
+			s.webhookError(c, err)
+			return
+		}
+		if w.Secret == "" {
+			w.Secret = existing.Secret
+		}
+		w.ID = id
+		status = http.StatusOK
+	}
+	if w.KeyID != nil {
+		keyID := dirtyKeyID(*w.KeyID)
+		if _, ok := s.keyMap[keyID]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("key %q not found", *w.KeyID)})
+			return
+		}
+		w.KeyID = &keyID
+	}
+	if err := webhook.Validate(&w); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.store.SaveWebhook(ctx, &w); err != nil {
+		s.webhookError(c, err)
+		return
+	}
+	saved, err := s.store.Webhook(ctx, w.ID)
+	if err != nil {
+		s.webhookError(c, err)
+		return
+	}
+	saved.Secret = ""
+	c.JSON(status, saved)
+}
+
+func (s *Server) deleteWebhook(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteWebhook(c.Request.Context(), id); err != nil {
+		s.webhookError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// pingWebhook queues a ping for the webhook
+func (s *Server) pingWebhook(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+	if s.webhooks == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhooks not enabled"})
+		return
+	}
+	if err := s.webhooks.Ping(c.Request.Context(), id); err != nil {
+		s.webhookError(c, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// getDeliveries returns the deliveries of the webhook, newest first,
+// optionally filtered by status
+func (s *Server) getDeliveries(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+	q := store.DeliveryQuery{WebhookID: id, Status: c.Query("status"), Limit: 100}
+	switch q.Status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or failed"})
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxDeliveries {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxDeliveries)})
+			return
+		}
+		q.Limit = l
+	}
+	if _, err := s.store.Webhook(c.Request.Context(), id); err != nil {
+		s.webhookError(c, err)
+		return
+	}
+	deliveries, err := s.store.Deliveries(c.Request.Context(), q)
+	if err != nil {
+		s.webhookError(c, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func webhookID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (s *Server) webhookError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	logger.Errorf("webhook request failed: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook request failed"})
+}
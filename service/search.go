@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	gw "github.com/denysvitali/searchparty-go/gen/proto"
+	"github.com/denysvitali/searchparty-go/server/store"
+)
+
+// SearchLocations returns the locations found within a bounding box or a circle
+func (s *Service) SearchLocations(ctx context.Context, request *gw.SearchLocationsRequest) (*gw.SearchLocationsResponse, error) {
+	q := store.AreaQuery{Limit: int(request.GetLimit())}
+	if request.GetTo() != nil {
+		q.To = request.GetTo().AsTime()
+	}
+	if request.GetFrom() != nil {
+		q.From = request.GetFrom().AsTime()
+	}
+	q.SetDefaults()
+	for _, id := range request.GetDeviceIds() {
+		keyID := strings.ReplaceAll(id, "-", "/")
+		if _, ok := s.keyMap[keyID]; !ok {
+			return nil, status.Errorf(codes.NotFound, "device %q not found", id)
+		}
+		q.KeyIDs = append(q.KeyIDs, keyID)
+	}
+	if b := request.GetBoundingBox(); b != nil {
+		q.BoundingBox = &store.BoundingBox{
+			MinLat: b.GetMinLatitude(),
+			MinLng: b.GetMinLongitude(),
+			MaxLat: b.GetMaxLatitude(),
+			MaxLng: b.GetMaxLongitude(),
+		}
+	}
+	if c := request.GetCircle(); c != nil {
+		q.Circle = &store.Circle{
+			Lat:    c.GetLatitude(),
+			Lng:    c.GetLongitude(),
+			Radius: c.GetRadiusMeters(),
+		}
+	}
+	if err := q.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	locations, err := s.store.LocationsWithin(ctx, q)
+	if err != nil {
+		log.Errorf("unable to search locations: %v", err)
+		return nil, status.Error(codes.Internal, "unable to search locations")
+	}
+	res := make([]*gw.DeviceLocation, 0, len(locations))
+	for _, l := range locations {
+		if l.Geometry == nil {
+			continue
+		}
+		res = append(res, &gw.DeviceLocation{
+			DeviceId: l.KeyID,
+			Location: toLocation(l),
+		})
+	}
+	return &gw.SearchLocationsResponse{Locations: res}, nil
+}
@@ -2,33 +2,45 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
-	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 
 	"github.com/denysvitali/searchparty-go"
 	gw "github.com/denysvitali/searchparty-go/gen/proto"
 	"github.com/denysvitali/searchparty-go/model"
+	"github.com/denysvitali/searchparty-go/server/events"
+	"github.com/denysvitali/searchparty-go/server/ingest"
+	"github.com/denysvitali/searchparty-go/server/models"
+	"github.com/denysvitali/searchparty-go/server/store"
 )
 
 var log = logrus.StandardLogger().WithField("pkg", "service")
 
+const (
+	// staleAfter is the time since the last fetch after which
+	// GetDeviceLocation fetches new reports from Apple
+	staleAfter = 15 * time.Minute
+	// refreshHours is how far back a refresh looks for new reports
+	refreshHours = 12
+)
+
 type Service struct {
-	anisetteURL    string
-	auth           *searchparty.Auth
-	beaconStoreKey []byte
-	db             *gorm.DB
-	keyMap         map[string]model.MainKey
+	c      searchparty.Finder
+	store  store.Store
+	keyMap map[string]model.MainKey
+	events *events.Broker
 
 	gw.UnimplementedSearchPartyServer
 }
@@ -55,46 +67,119 @@ func toDevices(keyMap map[string]model.MainKey) []*gw.Device {
 }
 
 func (s *Service) GetDeviceLocation(ctx context.Context, request *gw.GetDeviceLocationRequest) (*gw.GetDeviceLocationResponse, error) {
-	//TODO implement me
-	panic("implement me")
-}
+	if request.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	// IDs are base64 encoded, "/" is replaced with "-" to keep them URL friendly
+	keyID := strings.ReplaceAll(request.GetId(), "-", "/")
+	key, ok := s.keyMap[keyID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "device %q not found", request.GetId())
+	}
 
-var _ gw.SearchPartyServer = (*Service)(nil)
+	if request.GetRefresh() || s.isStale(ctx, keyID) {
+		if err := s.refreshLocations(ctx, key); err != nil {
+			log.Warnf("unable to refresh locations for %q: %v", keyID, err)
+		}
+	}
 
-func New(auth *searchparty.Auth, anisetteURL string, dsn string, beaconStoreKey []byte) (*Service, error) {
-	db, err := gorm.Open(
-		postgres.New(postgres.Config{
-			DSN:        dsn,
-			DriverName: "postgres",
-		}),
-		&gorm.Config{},
-	)
+	locations, err := s.getLocations(ctx, keyID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
-	s := Service{
-		db:             db,
-		auth:           auth,
-		anisetteURL:    anisetteURL,
-		beaconStoreKey: beaconStoreKey,
-		keyMap:         map[string]model.MainKey{},
+		log.Errorf("unable to get locations: %v", err)
+		return nil, status.Error(codes.Internal, "unable to get locations")
 	}
-	err = s.init()
-	return &s, err
+
+	return &gw.GetDeviceLocationResponse{
+		Locations: toLocations(locations),
+	}, nil
+}
+
+func (s *Service) getLocations(ctx context.Context, keyID string) ([]models.Location, error) {
+	return s.store.Locations(ctx, keyID, time.Time{}, time.Now())
 }
 
-func (s *Service) init() error {
-	keys, err := searchparty.LoadKeys("./beacons/", s.beaconStoreKey)
+// isStale returns true when the reports of keyID weren't fetched in the
+// last staleAfter, by a previous call or by the scheduler
+func (s *Service) isStale(ctx context.Context, keyID string) bool {
+	info, err := s.store.KeyInfo(ctx, keyID)
 	if err != nil {
-		return fmt.Errorf("failed to load keys: %w", err)
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Warnf("unable to fetch key info: %v", err)
+		}
+		return true
+	}
+	return info.LastFetchedAt == nil || time.Since(*info.LastFetchedAt) > staleAfter
+}
+
+// refreshLocations fetches and stores the reports of the last refreshHours,
+// recording the fetch so the following calls don't repeat it
+func (s *Service) refreshLocations(ctx context.Context, key model.MainKey) error {
+	now := time.Now()
+	if _, err := ingest.Fetch(ctx, s.c, s.store, key, now.Add(-refreshHours*time.Hour), now); err != nil {
+		return fmt.Errorf("unable to find reports: %w", err)
 	}
-	for _, k := range keys {
-		s.keyMap[k.ID()] = k
+	if err := s.store.SetLastFetchedAt(ctx, key.ID(), now); err != nil {
+		log.Warnf("unable to save the last fetched time of %s: %v", key.ID(), err)
 	}
 	return nil
 }
 
-func (s *Service) Start(grpcListenAddr string, httpListenAddr string) error {
+func toLocations(locations []models.Location) []*gw.Location {
+	res := make([]*gw.Location, 0, len(locations))
+	for _, l := range locations {
+		if l.Geometry == nil {
+			continue
+		}
+		res = append(res, toLocation(l))
+	}
+	return res
+}
+
+func toLocation(l models.Location) *gw.Location {
+	return &gw.Location{
+		Latitude:   float32(l.Geometry.Coords().Y()),
+		Longitude:  float32(l.Geometry.Coords().X()),
+		Accuracy:   int32(l.Accuracy()), //nolint:gosec
+		Timestamp:  timestamppb.New(l.FoundAt),
+		Battery:    toBatteryLevel(l.DecodedStatus().Battery()),
+		Maintained: l.DecodedStatus().Maintained(),
+		Status:     uint32(l.DecodedStatus()),
+	}
+}
+
+// toBatteryLevel converts a decoded battery level to its proto enum
+func toBatteryLevel(b searchparty.BatteryLevel) gw.BatteryLevel {
+	switch b {
+	case searchparty.BatteryFull:
+		return gw.BatteryLevel_BATTERY_LEVEL_FULL
+	case searchparty.BatteryMedium:
+		return gw.BatteryLevel_BATTERY_LEVEL_MEDIUM
+	case searchparty.BatteryLow:
+		return gw.BatteryLevel_BATTERY_LEVEL_LOW
+	case searchparty.BatteryCritical:
+		return gw.BatteryLevel_BATTERY_LEVEL_CRITICAL
+	default:
+		return gw.BatteryLevel_BATTERY_LEVEL_UNSPECIFIED
+	}
+}
+
+var _ gw.SearchPartyServer = (*Service)(nil)
+
+// New returns a Service fetching the reports with finder, either a
+// *searchparty.Client or a *searchparty.AccountPool, and storing the
+// locations in st
+func New(finder searchparty.Finder, st store.Store, keyMap map[string]model.MainKey) *Service {
+	return &Service{
+		store:  st,
+		c:      finder,
+		keyMap: keyMap,
+	}
+}
+
+// Start serves the gRPC API on grpcListenAddr and the gateway on
+// httpListenAddr. If rest is not nil, it's mounted under /api/ next to the
+// gateway, sharing the same listener.
+func (s *Service) Start(grpcListenAddr string, httpListenAddr string, rest http.Handler) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	mux := runtime.NewServeMux()
@@ -117,9 +202,16 @@ func (s *Service) Start(grpcListenAddr string, httpListenAddr string) error {
 	if err := gw.RegisterSearchPartyHandlerFromEndpoint(ctx, mux, grpcListenAddr, opts); err != nil {
 		return fmt.Errorf("register gateway: %w", err)
 	}
+	var handler http.Handler = mux
+	if rest != nil {
+		m := http.NewServeMux()
+		m.Handle("/api/", rest)
+		m.Handle("/", mux)
+		handler = m
+	}
 	httpServer := &http.Server{
 		Addr:              httpListenAddr,
-		Handler:           mux,
+		Handler:           handler,
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       30 * time.Second,
 	}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	gw "github.com/denysvitali/searchparty-go/gen/proto"
+	"github.com/denysvitali/searchparty-go/server/events"
+	"github.com/denysvitali/searchparty-go/server/models"
+)
+
+// SetEvents sets the broker of WatchDeviceLocations
+func (s *Service) SetEvents(b *events.Broker) {
+	s.events = b
+}
+
+// WatchDeviceLocations streams the new locations and status changes of the
+// devices, after the locations stored since the cursor request.Since
+func (s *Service) WatchDeviceLocations(request *gw.WatchDeviceLocationsRequest, stream gw.SearchParty_WatchDeviceLocationsServer) error {
+	if s.events == nil {
+		return status.Error(codes.Unavailable, "live streams not enabled")
+	}
+	var keyIDs []string
+	for _, id := range request.GetDeviceIds() {
+		keyID := strings.ReplaceAll(id, "-", "/")
+		if _, ok := s.keyMap[keyID]; !ok {
+			return status.Errorf(codes.NotFound, "device %q not found", id)
+		}
+		keyIDs = append(keyIDs, keyID)
+	}
+	var since time.Time
+	if request.GetSince() != nil {
+		since = request.GetSince().AsTime()
+	}
+
+	err := events.Stream(stream.Context(), s.store, s.events, keyIDs, since, func(e events.Event) error {
+		res := toWatchResponse(e)
+		if res == nil {
+			return nil
+		}
+		return stream.Send(res)
+	})
+	switch {
+	case errors.Is(err, events.ErrOverflow):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return err
+	}
+	return nil
+}
+
+func toWatchResponse(e events.Event) *gw.WatchDeviceLocationsResponse {
+	res := &gw.WatchDeviceLocationsResponse{DeviceId: e.KeyID}
+	switch {
+	case e.Location != nil:
+		res.Event = &gw.WatchDeviceLocationsResponse_Location{Location: &gw.Location{
+			Latitude:   float32(e.Location.Lat),
+			Longitude:  float32(e.Location.Lng),
+			Accuracy:   int32(models.AccuracyFromConfidence(e.Location.Confidence)), //nolint:gosec
+			Timestamp:  timestamppb.New(e.Location.FoundAt),
+			Battery:    toBatteryLevel(e.Location.Battery),
+			Maintained: e.Location.Maintained,
+			Status:     uint32(e.Location.Status), //nolint:gosec
+		}}
+		res.StoredAt = timestamppb.New(e.Cursor)
+	case e.Key != nil:
+		deviceStatus := &gw.DeviceStatus{Lost: e.Key.LostAt != nil}
+		if e.Key.LostAt != nil {
+			deviceStatus.LostAt = timestamppb.New(*e.Key.LostAt)
+		}
+		res.Event = &gw.WatchDeviceLocationsResponse_Status{Status: deviceStatus}
+	default:
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package searchparty
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Status is the status byte of a report. AirTags and most Find My
+// accessories encode their battery state in the two most significant bits
+// and some maintenance flags in the others.
+type Status uint8
+
+// Bits of the status byte
+const (
+	statusBatteryShift = 6
+	statusBatteryMask  = 0b11 << statusBatteryShift
+	// StatusMaintained is set when the accessory was connected to its owner
+	// device recently (about 15 minutes)
+	StatusMaintained Status = 1 << 2
+	// statusFlagsMask covers the bits that don't encode the battery state
+	statusFlagsMask = ^Status(statusBatteryMask)
+)
+
+// BatteryLevel is the battery state reported in the status byte, from the
+// fullest to the emptiest
+type BatteryLevel uint8
+
+const (
+	BatteryFull BatteryLevel = iota
+	BatteryMedium
+	BatteryLow
+	BatteryCritical
+)
+
+var batteryNames = []string{"full", "medium", "low", "critical"}
+
+// String returns the name of b: full, medium, low or critical
+func (b BatteryLevel) String() string {
+	if int(b) < len(batteryNames) {
+		return batteryNames[b]
+	}
+	return fmt.Sprintf("BatteryLevel(%d)", uint8(b))
+}
+
+// MarshalText encodes b as its name
+func (b BatteryLevel) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText decodes the name of a battery level
+func (b *BatteryLevel) UnmarshalText(text []byte) error {
+	for i, name := range batteryNames {
+		if strings.EqualFold(name, string(text)) {
+			*b = BatteryLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown battery level %q", text)
+}
+
+// Percent estimates the battery percentage of b
+func (b BatteryLevel) Percent() int {
+	switch b {
+	case BatteryFull:
+		return 100
+	case BatteryMedium:
+		return 50
+	case BatteryLow:
+		return 20
+	default:
+		return 5
+	}
+}
+
+// IsLow returns whether the battery is low or critical
+func (b BatteryLevel) IsLow() bool {
+	return b >= BatteryLow
+}
+
+// Battery returns the battery level encoded in s
+func (s Status) Battery() BatteryLevel {
+	return BatteryLevel((s & statusBatteryMask) >> statusBatteryShift)
+}
+
+// Maintained returns whether StatusMaintained is set
+func (s Status) Maintained() bool {
+	return s&StatusMaintained != 0
+}
+
+// Flags returns the bits of s that don't encode the battery level
+func (s Status) Flags() Status {
+	return s & statusFlagsMask
+}
+
+// String returns the decoded status, e.g. "battery=low,maintained,raw=0x84"
+func (s Status) String() string {
+	parts := []string{"battery=" + s.Battery().String()}
+	if s.Maintained() {
+		parts = append(parts, "maintained")
+	}
+	parts = append(parts, fmt.Sprintf("raw=0x%02x", uint8(s)))
+	return strings.Join(parts, ",")
+}
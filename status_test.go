@@ -0,0 +1,75 @@
+package searchparty
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		status     Status
+		battery    BatteryLevel
+		maintained bool
+		flags      Status
+		str        string
+	}{
+		{0x00, BatteryFull, false, 0, "battery=full,raw=0x00"},
+		{0x44, BatteryMedium, true, 0x04, "battery=medium,maintained,raw=0x44"},
+		{0x90, BatteryLow, false, 0x10, "battery=low,raw=0x90"},
+		{0xe4, BatteryCritical, true, 0x24, "battery=critical,maintained,raw=0xe4"},
+	}
+	for _, tt := range tests {
+		if got := tt.status.Battery(); got != tt.battery {
+			t.Errorf("%#x: expected battery %s, got %s", uint8(tt.status), tt.battery, got)
+		}
+		if got := tt.status.Maintained(); got != tt.maintained {
+			t.Errorf("%#x: expected maintained %v, got %v", uint8(tt.status), tt.maintained, got)
+		}
+		if got := tt.status.Flags(); got != tt.flags {
+			t.Errorf("%#x: expected flags %#x, got %#x", uint8(tt.status), uint8(tt.flags), uint8(got))
+		}
+		if got := tt.status.String(); got != tt.str {
+			t.Errorf("%#x: expected %q, got %q", uint8(tt.status), tt.str, got)
+		}
+	}
+}
+
+func TestBatteryLevelText(t *testing.T) {
+	for _, b := range []BatteryLevel{BatteryFull, BatteryMedium, BatteryLow, BatteryCritical} {
+		text, err := b.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText failed: %v", err)
+		}
+		var got BatteryLevel
+		if err := got.UnmarshalText(text); err != nil || got != b {
+			t.Errorf("expected %s, got %s (%v)", b, got, err)
+		}
+	}
+	if err := new(BatteryLevel).UnmarshalText([]byte("empty")); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+	if !BatteryLow.IsLow() || !BatteryCritical.IsLow() || BatteryMedium.IsLow() {
+		t.Errorf("unexpected IsLow")
+	}
+}
+
+func TestDecodeTagStatus(t *testing.T) {
+	td, err := decodeTag([]byte{0x1c, 0x30, 0x3a, 0x20, 0x05, 0x17, 0x5a, 0x60, 0x0c, 0x84})
+	if err != nil {
+		t.Fatalf("decodeTag failed: %v", err)
+	}
+	if td.Status != 0x84 || td.Battery != BatteryLow || !td.Maintained {
+		t.Errorf("unexpected status %d, battery %s, maintained %v", td.Status, td.Battery, td.Maintained)
+	}
+	b, err := json.Marshal(td)
+	if err != nil {
+		t.Fatalf("unable to marshal: %v", err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("unable to unmarshal: %v", err)
+	}
+	if m["status"] != float64(0x84) || m["battery"] != "low" || m["maintained"] != true {
+		t.Errorf("unexpected json %s", b)
+	}
+}